@@ -0,0 +1,192 @@
+// Pipelined batch publish and push for the redis implementation of IMessageBus
+//
+
+package facilities
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// BatchMode defines how a batch of messages is sent (see PublishBatch and PushBatch).
+type BatchMode int
+
+const (
+	BatchBestEffort BatchMode = iota // Every message is sent independently, failed messages do not affect the others
+	BatchAtomic                      // All the messages are sent in a single transaction (MULTI/EXEC), or none of them
+)
+
+// ErrBatchAborted is the error of the messages of an atomic batch which was not sent because another message failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// ErrBatchRetry is returned when a publish middleware calls the next function more than once for a message of an
+// atomic batch: the message is part of a transaction which was already executed, so it cannot be sent again.
+var ErrBatchRetry = errors.New("message of an atomic batch sent more than once")
+
+// MessageResult is the result of sending a message of a batch. A message may be delivered and still have an error,
+// when a publish middleware fails after the message was sent: such a message must not be sent again.
+type MessageResult struct {
+	Message   IMessage // The message
+	Receivers int64    // The number of subscribers which received the message (publish), or the queue length (push)
+	Delivered bool     // The message was sent to redis (and accepted)
+	Err       error    // The error, or nil if the message was sent
+}
+
+// BatchResult is the report of a batch, with the result of every message in the order of the messages.
+type BatchResult []MessageResult
+
+// Sent returns the number of messages which were delivered, including those reported with a middleware error.
+func (b BatchResult) Sent() (sent int) {
+	for _, result := range b {
+		if result.Delivered {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Err returns the errors of the messages (except the aborted ones), or nil if all the messages were sent without error.
+func (b BatchResult) Err() error {
+	errs := make([]error, 0)
+	for i, result := range b {
+		if result.Err != nil && !errors.Is(result.Err, ErrBatchAborted) {
+			errs = append(errs, fmt.Errorf("message %d (%s): %w", i, result.Message.Topic(), result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishBatch publishes messages to their channels (topics) in a single round-trip (using a pipeline).
+// It returns the result of every message, and the errors of the failed messages (see BatchResult.Err).
+func (r *RedisAdapter) PublishBatch(mode BatchMode, messages ...IMessage) (BatchResult, error) {
+	return r.sendBatch(mode, messages, func(c redis.Cmdable, key string, data []byte) *redis.IntCmd {
+		return c.Publish(r.ctx, key, data)
+	})
+}
+
+// PushBatch appends messages to their queues (using LPush) in a single round-trip (using a pipeline).
+// It returns the result of every message, and the errors of the failed messages (see BatchResult.Err).
+func (r *RedisAdapter) PushBatch(mode BatchMode, messages ...IMessage) (BatchResult, error) {
+	return r.sendBatch(mode, messages, func(c redis.Cmdable, key string, data []byte) *redis.IntCmd {
+		return c.LPush(r.ctx, key, data)
+	})
+}
+
+// sendBatch serializes messages and sends them using a pipeline, or a transaction in atomic mode. The publish
+// middlewares of every message run concurrently, and wrap the actual sending of the message like for a single
+// message: the next function returns once the pipeline is executed, with the result of the message. When the next
+// function is called again (e.g. to retry), the message is sent on its own in best-effort mode, and ErrBatchRetry is
+// returned in atomic mode. The messages are sent in their order, whatever the order of the middlewares.
+// In atomic mode, nothing is sent if a message fails to serialize or is rejected by a middleware; like any redis
+// transaction, a command failing in redis (e.g. pushing to a key of another type) does not roll back the others.
+// On a Redis Cluster, a transaction is atomic only for the messages of the same hash slot.
+func (r *RedisAdapter) sendBatch(mode BatchMode, messages []IMessage, send func(c redis.Cmdable, key string, data []byte) *redis.IntCmd) (BatchResult, error) {
+
+	results := make(BatchResult, len(messages))
+	if len(messages) == 0 {
+		return results, nil
+	}
+
+	// Every message is either queued or done (without being queued) before the pipeline is executed
+	keys := make([]string, len(messages))
+	data := make([][]byte, len(messages))
+	cmds := make([]*redis.IntCmd, len(messages))
+	aborted := false
+	executed := make(chan struct{})
+
+	var queued, done sync.WaitGroup
+	queued.Add(len(messages))
+	done.Add(len(messages))
+
+	// record sets the result of a sent message
+	record := func(i int, cmd *redis.IntCmd) error {
+		receivers, err := cmd.Result()
+		if err == nil {
+			results[i].Receivers = receivers
+			results[i].Delivered = true
+		}
+		return err
+	}
+
+	for i, message := range messages {
+		results[i].Message = message
+		go func(i int, message IMessage) {
+			defer done.Done()
+			var once sync.Once
+			ready := func() { once.Do(queued.Done) }
+			defer ready()
+
+			sent := false
+			results[i].Err = chainPublish(func(msg IMessage) error {
+				if sent {
+					// The message was already sent (or failed) in the batch
+					if mode == BatchAtomic {
+						return ErrBatchRetry
+					}
+					if bytes, err := r.serializer.messageToRaw(msg.Topic(), msg); err != nil {
+						return err
+					} else {
+						return record(i, send(r.rc, r.ns.key(msg.Topic()), bytes))
+					}
+				}
+				sent = true
+				bytes, err := r.serializer.messageToRaw(msg.Topic(), msg)
+				if err != nil {
+					return err
+				}
+				keys[i], data[i] = r.ns.key(msg.Topic()), bytes
+				ready()
+				<-executed
+				if aborted {
+					return ErrBatchAborted
+				}
+				return record(i, cmds[i])
+			}, r.pubMiddlewares)(message)
+		}(i, message)
+	}
+	queued.Wait()
+
+	// In atomic mode, the batch is aborted if a message failed before being queued
+	if mode == BatchAtomic {
+		for i := range messages {
+			if data[i] == nil && results[i].Err != nil {
+				aborted = true
+				break
+			}
+		}
+	}
+
+	if !aborted {
+		var pipe redis.Pipeliner
+		if mode == BatchAtomic {
+			pipe = r.rc.TxPipeline()
+		} else {
+			pipe = r.rc.Pipeline()
+		}
+		for i := range messages {
+			if data[i] != nil {
+				cmds[i] = send(pipe, keys[i], data[i])
+			}
+		}
+		if pipe.Len() > 0 {
+			// The errors are reported by the commands
+			_, _ = pipe.Exec(r.ctx)
+		}
+	}
+	close(executed)
+	done.Wait()
+
+	if aborted {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBatchAborted
+			}
+		}
+	}
+	return results, results.Err()
+}
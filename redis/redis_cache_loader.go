@@ -0,0 +1,231 @@
+// Cache-aside (read-through) loading with stampede protection for the redis implementation of IDataCache
+//
+
+package facilities
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/logger"
+)
+
+const (
+	defaultLoadLockTTL  = 5 * time.Second
+	loadLockPrefix      = "yaaf:loading:"
+	loadPollingInterval = 50 * time.Millisecond
+)
+
+// loadFrame identifies a value stored by GetOrLoad with early refresh (the byte following the frameMarker).
+// The frame holds the load duration and the expiration of the value, so a cache hit decides on an early refresh
+// without another round trip. The layout of the frame is:
+// frameMarker | loadFrame | load duration in microseconds (uvarint) | expiration in unix milliseconds (uvarint) | value
+const loadFrame byte = 0x30
+
+// ErrLoadTimeout is returned by GetOrLoad when another process holds the load lock of a key and does not
+// populate it within the lock wait time (see WithLoadLock).
+var ErrLoadTimeout = errors.New("timeout waiting for the key to be loaded by another process")
+
+// EntityLoader loads an entity from the source of truth (e.g. a database) on a cache miss.
+type EntityLoader func() (Entity, error)
+
+// LoadOption configures the behavior of GetOrLoad.
+type LoadOption func(*loadOptions)
+
+// loadOptions holds the GetOrLoad configuration.
+type loadOptions struct {
+	lockTTL  time.Duration // TTL of the cross-process load lock
+	lockWait time.Duration // maximum time to wait for another process to populate the key
+	beta     float64       // early refresh factor (0 disables early refresh)
+}
+
+// WithLoadLock sets the TTL of the distributed lock taken while loading a key, and the maximum time
+// other processes wait for the lock holder to populate the key (or for the lock to be released, after which
+// one of them loads the key) before GetOrLoad returns ErrLoadTimeout.
+// The lock TTL should be longer than the expected duration of the loader.
+func WithLoadLock(ttl, wait time.Duration) LoadOption {
+	return func(o *loadOptions) {
+		o.lockTTL = ttl
+		o.lockWait = wait
+	}
+}
+
+// WithEarlyRefresh enables probabilistic early refresh (the XFetch algorithm): as a key approaches its
+// expiration, callers have a growing chance to reload it in the background before it expires, so hot keys
+// never go cold. Beta > 1 favors earlier refreshes, beta < 1 favors later ones, 1 is a good default.
+// The probability is derived from the duration of the last load of the key, stored in a frame along with the value
+// (see loadFrame): Get and GetOrLoad decode such values, GetRaw returns them with the frame.
+func WithEarlyRefresh(beta float64) LoadOption {
+	return func(o *loadOptions) {
+		o.beta = beta
+	}
+}
+
+// GetOrLoad gets the value of a key and decodes it into an entity. On a cache miss it calls the loader
+// and stores the result with the given expiration (0 means no expiration).
+//
+// Concurrent calls for the same key within the process are de-duplicated so the loader runs once, and
+// a short-lived distributed lock makes sure only one process calls the loader at a time; the others wait
+// for the value to appear in the cache, and get ErrLoadTimeout if it does not appear within the lock wait time.
+func (r *RedisAdapter) GetOrLoad(factory EntityFactory, key string, ttl time.Duration, loader EntityLoader, options ...LoadOption) (Entity, error) {
+
+	opts := loadOptions{lockTTL: defaultLoadLockTTL}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.lockWait <= 0 {
+		opts.lockWait = opts.lockTTL
+	}
+
+	bytes, err := r.GetRaw(key)
+	if err == nil {
+		if opts.beta > 0 && ttl > 0 && shouldRefreshEarly(bytes, opts.beta) {
+			go r.refresh(key, ttl, loader, opts)
+		}
+		return r.serializer.rawToEntity(key, factory, bytes)
+	}
+	if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	// Cache miss: let a single goroutine do the work, the others share its result
+	result, err, _ := r.loads.Do(key, func() (any, error) {
+		return r.load(key, ttl, loader, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Each caller decodes its own copy, so callers never share (and mutate) the same entity instance
+	return r.serializer.rawToEntity(key, factory, result.([]byte))
+}
+
+// load coordinates the load of a key across processes and returns the raw value.
+func (r *RedisAdapter) load(key string, ttl time.Duration, loader EntityLoader, opts loadOptions) ([]byte, error) {
+
+	deadline := time.Now().Add(opts.lockWait)
+	for {
+		// Another process may have populated the key in the meantime
+		if bytes, err := r.GetRaw(key); err == nil {
+			return bytes, nil
+		} else if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		locker, err := r.tryLock(loadLockPrefix+key, opts.lockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if locker != nil {
+			defer func() { _ = locker.Release(r.ctx) }()
+
+			// Double-check, the previous lock holder may have just finished
+			if bytes, er := r.GetRaw(key); er == nil {
+				return bytes, nil
+			}
+			return r.loadAndStore(key, ttl, loader, opts)
+		}
+
+		// The lock is held by another process, wait for it to populate the key (loading without the lock
+		// would let every waiter call a slow loader at once)
+		if time.Now().After(deadline) {
+			logger.Warn("GetOrLoad: timeout waiting for key %s to be loaded by another process", key)
+			return nil, ErrLoadTimeout
+		}
+		time.Sleep(loadPollingInterval)
+	}
+}
+
+// refresh reloads a key in the background, unless another goroutine or process is already loading it.
+func (r *RedisAdapter) refresh(key string, ttl time.Duration, loader EntityLoader, opts loadOptions) {
+	_, _, _ = r.loads.Do(loadLockPrefix+key, func() (any, error) {
+		locker, err := r.tryLock(loadLockPrefix+key, opts.lockTTL)
+		if err != nil || locker == nil {
+			return nil, err
+		}
+		defer func() { _ = locker.Release(r.ctx) }()
+
+		if _, er := r.loadAndStore(key, ttl, loader, opts); er != nil {
+			logger.Warn("GetOrLoad: early refresh of key %s failed: %s", key, er.Error())
+		}
+		return nil, nil
+	})
+}
+
+// loadAndStore calls the loader and stores the result in the cache. With early refresh, the value is stored in a
+// frame holding the load duration and the expiration of the value (see loadFrame).
+func (r *RedisAdapter) loadAndStore(key string, ttl time.Duration, loader EntityLoader, opts loadOptions) ([]byte, error) {
+	start := time.Now()
+	entity, err := loader()
+	if err != nil {
+		return nil, err
+	}
+	delta := time.Since(start)
+
+	bytes, err := r.serializer.entityToRaw(key, entity)
+	if err != nil {
+		return nil, err
+	}
+	if opts.beta > 0 && ttl > 0 {
+		bytes = encodeLoadFrame(delta, time.Now().Add(ttl), bytes)
+	}
+	if err = r.SetRaw(key, bytes, ttl); err != nil {
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// shouldRefreshEarly implements the XFetch decision on a value stored with its load frame: refresh when
+// remaining TTL <= delta * beta * -ln(rand), where delta is the duration of the last load.
+func shouldRefreshEarly(data []byte, beta float64) bool {
+	delta, expiration, _, ok := decodeLoadFrame(data)
+	if !ok {
+		return false
+	}
+	remaining := time.Until(expiration)
+	if remaining <= 0 {
+		return false
+	}
+	gap := float64(delta) * beta * -math.Log(1-rand.Float64())
+	return float64(remaining) <= gap
+}
+
+// encodeLoadFrame wraps a value in a load frame.
+func encodeLoadFrame(delta time.Duration, expiration time.Time, value []byte) []byte {
+	data := make([]byte, 0, 2+2*binary.MaxVarintLen64+len(value))
+	data = append(data, frameMarker, loadFrame)
+	data = binary.AppendUvarint(data, uint64(delta.Microseconds()))
+	data = binary.AppendUvarint(data, uint64(expiration.UnixMilli()))
+	return append(data, value...)
+}
+
+// decodeLoadFrame splits a load frame into the load duration, the expiration and the value.
+// It returns false if the data is not a valid load frame.
+func decodeLoadFrame(data []byte) (delta time.Duration, expiration time.Time, value []byte, ok bool) {
+	if len(data) < 2 || data[0] != frameMarker || data[1] != loadFrame {
+		return 0, time.Time{}, nil, false
+	}
+	micros, n := binary.Uvarint(data[2:])
+	if n <= 0 {
+		return 0, time.Time{}, nil, false
+	}
+	millis, m := binary.Uvarint(data[2+n:])
+	if m <= 0 {
+		return 0, time.Time{}, nil, false
+	}
+	return time.Duration(micros) * time.Microsecond, time.UnixMilli(int64(millis)), data[2+n+m:], true
+}
+
+// stripLoadFrame returns the value of a load frame, or the data as-is if it is not a load frame.
+func stripLoadFrame(data []byte) []byte {
+	if _, _, value, ok := decodeLoadFrame(data); ok {
+		return value
+	}
+	return data
+}
@@ -0,0 +1,229 @@
+// Tag based invalidation for the redis implementation of IDataCache
+//
+
+package facilities
+
+import (
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// tagIndexPrefix is the prefix of the reverse-index sets mapping a tag to its tagged keys.
+// Keys are stored as-is in the index, hash fields are stored as key + tagFieldSeparator + field.
+// tagPruneSample is the number of random members of an index checked on every tagging, the members whose key
+// (or hash field) no longer exists, e.g. expired, are removed from the index (not on a Redis Cluster).
+const (
+	tagIndexPrefix    = "yaaf:tags:"
+	tagFieldSeparator = "\x00"
+	tagPruneSample    = 10
+)
+
+var (
+	// luaTag adds a member to the tag index sets (KEYS) and keeps the index alive at least as long as the tagged entry.
+	// ARGV[1] is the member, ARGV[2] is the entry TTL in milliseconds (0 means no expiration), ARGV[3] is the number
+	// of random members checked, and removed if their key or hash field no longer exists (0 disables pruning).
+	// The checked members are not declared in KEYS (they are not known in advance), so with a restricted ACL key
+	// pattern the user must be allowed to read the tagged keys, or pruning fails the script.
+	luaTag = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+local sample = tonumber(ARGV[3])
+for _, k in ipairs(KEYS) do
+	if sample > 0 then
+		for _, m in ipairs(redis.call("srandmember", k, sample)) do
+			local i = string.find(m, "\0", 1, true)
+			local alive
+			if i then
+				alive = redis.call("hexists", string.sub(m, 1, i - 1), string.sub(m, i + 1))
+			else
+				alive = redis.call("exists", m)
+			end
+			if alive == 0 then
+				redis.call("srem", k, m)
+			end
+		end
+	end
+	local existed = redis.call("exists", k)
+	redis.call("sadd", k, ARGV[1])
+	if ttl == 0 then
+		redis.call("persist", k)
+	elseif existed == 0 then
+		redis.call("pexpire", k, ttl)
+	else
+		local cur = redis.call("pttl", k)
+		if cur >= 0 and cur < ttl then
+			redis.call("pexpire", k, ttl)
+		end
+	end
+end
+return 1`)
+
+	// luaInvalidate deletes all the keys and hash fields referenced by the tag index sets (KEYS) and the sets themselves.
+	// It returns the number of deleted keys and fields. The members are not declared in KEYS (they are not known in
+	// advance), so with a restricted ACL key pattern the user must be allowed to write the tagged keys.
+	luaInvalidate = redis.NewScript(`
+local n = 0
+for _, t in ipairs(KEYS) do
+	for _, m in ipairs(redis.call("smembers", t)) do
+		local i = string.find(m, "\0", 1, true)
+		if i then
+			n = n + redis.call("hdel", string.sub(m, 1, i - 1), string.sub(m, i + 1))
+		else
+			n = n + redis.call("del", m)
+		end
+	end
+	redis.call("del", t)
+end
+return n`)
+)
+
+// region Tagged actions -------------------------------------------------------------------------------------------
+
+// SetTagged sets the value of a key from an entity and associates the key with one or more tags,
+// with an optional expiration. All the keys sharing a tag can be deleted together using InvalidateTags.
+func (r *RedisAdapter) SetTagged(key string, entity Entity, tags []string, expiration ...time.Duration) error {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
+		return err
+	} else {
+		return r.SetRawTagged(key, bytes, tags, expiration...)
+	}
+}
+
+// SetRawTagged sets the value of a key from a byte array and associates the key with one or more tags,
+// with an optional expiration.
+func (r *RedisAdapter) SetRawTagged(key string, bytes []byte, tags []string, expiration ...time.Duration) error {
+	var exp time.Duration = 0
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	cmds, err := r.tagPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(r.ctx, r.ns.key(key), bytes, exp)
+		r.tag(pipe, r.ns.key(key), tags, exp)
+		return nil
+	})
+	// On a Redis Cluster, the key may be set even if tagging failed
+	if len(cmds) > 0 && cmds[0].Err() == nil {
+		r.invalidateLocal(key)
+	}
+	return err
+}
+
+// HSetTagged sets the value of a hash field from an entity and associates the field with one or more tags.
+// InvalidateTags deletes the tagged field only, not the entire hash.
+func (r *RedisAdapter) HSetTagged(key, field string, entity Entity, tags []string) error {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
+		return err
+	} else {
+		return r.HSetRawTagged(key, field, bytes, tags)
+	}
+}
+
+// HSetRawTagged sets the raw value of a hash field from a byte array and associates the field with one or more tags.
+func (r *RedisAdapter) HSetRawTagged(key, field string, bytes []byte, tags []string) error {
+	_, err := r.tagPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.ctx, r.ns.key(key), field, bytes)
+		r.tag(pipe, r.ns.key(key)+tagFieldSeparator+field, tags, 0)
+		return nil
+	})
+	return err
+}
+
+// InvalidateTags atomically deletes all the keys and hash fields associated with any of the given tags,
+// and removes the tags' indexes. It returns the number of deleted keys and hash fields.
+//
+// On a Redis Cluster, the tagged keys and the tag indexes may belong to different hash slots, so the keys
+// are deleted tag by tag, and not atomically: an entry tagged while the tag is invalidated may remain.
+func (r *RedisAdapter) InvalidateTags(tags ...string) (int64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	var deleted int64
+	var err error
+	if r.isCluster() {
+		deleted, err = r.invalidateClusterTags(r.ns.keys(tagIndexKeys(tags)))
+	} else {
+		deleted, err = luaInvalidate.Run(r.ctx, r.rc, r.ns.keys(tagIndexKeys(tags))).Int64()
+	}
+	// The tagged keys are not known in advance, so the local caches are cleared entirely (unless nothing was deleted
+	// because of the error)
+	if err == nil || deleted > 0 {
+		r.invalidateLocal()
+	}
+	return deleted, err
+}
+
+// invalidateClusterTags deletes the keys and hash fields of the tag indexes (including the namespace) one index
+// at a time, removing from the index only the members read, so members tagged in the meantime are kept.
+func (r *RedisAdapter) invalidateClusterTags(indexes []string) (int64, error) {
+	var deleted int64
+	for _, index := range indexes {
+		members, err := r.rc.SMembers(r.ctx, index).Result()
+		if err != nil {
+			return deleted, err
+		} else if len(members) == 0 {
+			continue
+		}
+		read := make([]any, 0, len(members))
+
+		cmds, err := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for _, member := range members {
+				read = append(read, member)
+				if key, field, ok := strings.Cut(member, tagFieldSeparator); ok {
+					pipe.HDel(r.ctx, key, field)
+				} else {
+					pipe.Del(r.ctx, member)
+				}
+			}
+			pipe.SRem(r.ctx, index, read...)
+			return nil
+		})
+		for _, cmd := range cmds[:len(members)] {
+			deleted += cmd.(*redis.IntCmd).Val()
+		}
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// tag adds the tagging of a member (a key or a hash field in redis, including the namespace) to the pipeline,
+// pruning the members of the indexes which no longer exist. On a Redis Cluster, every tag index is updated by its own
+// script, as the indexes may belong to different hash slots, and the indexes are not pruned: the members may belong
+// to other hash slots, and checking them from the client would race with a concurrent tagging of the same key (the
+// index is updated before the key is set). Such indexes rely on their expiration, and on InvalidateTags.
+func (r *RedisAdapter) tag(pipe redis.Pipeliner, member string, tags []string, ttl time.Duration) {
+	if len(tags) == 0 {
+		return
+	}
+	if !r.isCluster() {
+		luaTag.Eval(r.ctx, pipe, r.ns.keys(tagIndexKeys(tags)), member, ttl.Milliseconds(), tagPruneSample)
+		return
+	}
+	for _, index := range r.ns.keys(tagIndexKeys(tags)) {
+		luaTag.Eval(r.ctx, pipe, []string{index}, member, ttl.Milliseconds(), 0)
+	}
+}
+
+// tagPipelined runs the commands setting a tagged entry in a transaction. On a Redis Cluster, the entry and its tag
+// indexes may belong to different hash slots, so the commands are sent in a pipeline, and not atomically.
+func (r *RedisAdapter) tagPipelined(fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if r.isCluster() {
+		return r.rc.Pipelined(r.ctx, fn)
+	}
+	return r.rc.TxPipelined(r.ctx, fn)
+}
+
+// tagIndexKeys converts tags to the keys of their index sets.
+func tagIndexKeys(tags []string) []string {
+	keys := make([]string, 0, len(tags))
+	for _, t := range tags {
+		keys = append(keys, tagIndexPrefix+strings.TrimSpace(t))
+	}
+	return keys
+}
+
+// endregion
@@ -0,0 +1,161 @@
+// Pluggable serialization codecs for entities and messages
+//
+
+package facilities
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// Codec converts entities and messages to and from their raw representation stored in redis.
+type Codec interface {
+	// Name returns the codec name (e.g. "json")
+	Name() string
+	// Marshal encodes a value to raw data
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes raw data into a value (a pointer to an entity or a message)
+	Unmarshal(data []byte, v any) error
+}
+
+// WithCodec sets the default codec of all the keys and topics (JSONCodec by default).
+func WithCodec(codec Codec) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.keys.codec = codec
+		r.serializer.topics.codec = codec
+	}
+}
+
+// WithKeyCodec sets the codec of the keys (including hashes and lists) starting with the given prefix,
+// overriding the default codec. When several prefixes match a key, the longest one is used.
+func WithKeyCodec(prefix string, codec Codec) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.keys.add(prefix, codec)
+	}
+}
+
+// WithTopicCodec sets the codec of the messages published or pushed to topics (or queues) starting with the
+// given prefix, overriding the default codec. When several prefixes match a topic, the longest one is used.
+func WithTopicCodec(prefix string, codec Codec) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.topics.add(prefix, codec)
+	}
+}
+
+// region Built-in codecs ----------------------------------------------------------------------------------------------
+
+// JSONCodec is the default codec, based on the yaaf-common Marshal and Unmarshal functions.
+type JSONCodec struct{}
+
+// Name returns the codec name.
+func (JSONCodec) Name() string { return "json" }
+
+// Marshal encodes a value to raw data.
+func (JSONCodec) Marshal(v any) ([]byte, error) { return Marshal(v) }
+
+// Unmarshal decodes raw data into a value.
+func (JSONCodec) Unmarshal(data []byte, v any) error { return Unmarshal(data, &v) }
+
+// MsgPackCodec is a MessagePack codec. Fields are mapped using the `msgpack` struct tags,
+// or the `json` struct tags when no `msgpack` tag is defined.
+type MsgPackCodec struct{}
+
+// Name returns the codec name.
+func (MsgPackCodec) Name() string { return "msgpack" }
+
+// Marshal encodes a value to raw data.
+func (MsgPackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes raw data into a value.
+func (MsgPackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// CBORCodec is a CBOR (RFC 8949) codec. Fields are mapped using the `cbor` or `json` struct tags.
+type CBORCodec struct{}
+
+// Name returns the codec name.
+func (CBORCodec) Name() string { return "cbor" }
+
+// Marshal encodes a value to raw data.
+func (CBORCodec) Marshal(v any) ([]byte, error) { return cbor.Marshal(v) }
+
+// Unmarshal decodes raw data into a value.
+func (CBORCodec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+
+// GobCodec is a codec based on the Go encoding/gob package. It is suitable only when all the readers are written in Go.
+// Every value is encoded as a self-contained gob stream, including its type definition.
+type GobCodec struct{}
+
+// Name returns the codec name.
+func (GobCodec) Name() string { return "gob" }
+
+// Marshal encodes a value to raw data.
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes raw data into a value.
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// endregion
+
+// region Codec selection ----------------------------------------------------------------------------------------------
+
+// prefixCodec maps a key or topic prefix to a codec.
+type prefixCodec struct {
+	prefix string
+	codec  Codec
+}
+
+// codecRegistry selects the codec of a key or a topic: the codec of the longest matching prefix, or the default codec.
+type codecRegistry struct {
+	codec    Codec
+	prefixes []prefixCodec
+}
+
+// add registers a codec for a prefix, keeping the longest prefixes first.
+func (c *codecRegistry) add(prefix string, codec Codec) {
+	c.prefixes = append(c.prefixes, prefixCodec{prefix: prefix, codec: codec})
+	sort.SliceStable(c.prefixes, func(i, j int) bool {
+		return len(c.prefixes[i].prefix) > len(c.prefixes[j].prefix)
+	})
+}
+
+// get returns the codec of a key or a topic.
+func (c *codecRegistry) get(name string) Codec {
+	for _, pc := range c.prefixes {
+		if strings.HasPrefix(name, pc.prefix) {
+			return pc.codec
+		}
+	}
+	if c.codec == nil {
+		return JSONCodec{}
+	}
+	return c.codec
+}
+
+// endregion
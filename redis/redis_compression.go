@@ -0,0 +1,185 @@
+// Transparent payload compression for entities and messages
+//
+
+package facilities
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is the compression algorithm of entities and messages.
+type Compression byte
+
+const (
+	NoCompression Compression = 0 // Values are stored as-is
+	Gzip          Compression = 1 // Gzip compression: good ratio, slower
+	Zstd          Compression = 2 // Zstandard compression: good ratio and speed (recommended)
+	Snappy        Compression = 3 // Snappy compression: fastest, lower ratio
+	LZ4           Compression = 4 // LZ4 compression: very fast, lower ratio
+)
+
+// frameMarker is the first byte of a framed (e.g. compressed) value, followed by a byte identifying the frame type.
+// It is never the first byte of a value produced by the built-in codecs (it is invalid in JSON and reserved in MessagePack),
+// so framed values and plain values are told apart safely, and plain values written before compression was enabled
+// remain readable.
+const frameMarker byte = 0xC1
+
+// maxDecompressedSize bounds the size of a decompressed value (the maximum size of a redis string),
+// protecting readers from decompression bombs.
+const maxDecompressedSize = 512 << 20
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdError   error
+)
+
+// WithCompression compresses the entities and messages written by the adapter using the given algorithm,
+// when their serialized size is at least threshold bytes. Compressed values are self-describing, so readers
+// detect compressed and plain values automatically regardless of their own compression settings.
+func WithCompression(algorithm Compression, threshold int) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.compression = algorithm
+		r.serializer.threshold = threshold
+	}
+}
+
+// String returns the algorithm name.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	case LZ4:
+		return "lz4"
+	default:
+		return fmt.Sprintf("compression(%d)", byte(c))
+	}
+}
+
+// compress compresses the data and adds the frame header. The data is returned as-is if it is smaller than
+// the threshold, or if the compression does not reduce its size.
+func compress(algorithm Compression, threshold int, data []byte) ([]byte, error) {
+	if algorithm == NoCompression || len(data) < threshold {
+		return data, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+	buf.WriteByte(frameMarker)
+	buf.WriteByte(byte(algorithm))
+
+	switch algorithm {
+	case Gzip:
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		buf.Write(zstdEncoder.EncodeAll(data, nil))
+	case Snappy:
+		buf.Write(snappy.Encode(nil, data))
+	case LZ4:
+		w := lz4.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// isCompressed checks if the data has a compression frame header.
+func isCompressed(data []byte) bool {
+	if len(data) < 2 || data[0] != frameMarker {
+		return false
+	}
+	switch Compression(data[1]) {
+	case Gzip, Zstd, Snappy, LZ4:
+		return true
+	default:
+		return false
+	}
+}
+
+// decompress decompresses the data if it has a compression frame header, otherwise it returns the data as-is.
+func decompress(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	algorithm, payload := Compression(data[1]), data[2:]
+	switch algorithm {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = r.Close() }()
+		return readLimited(r)
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(payload, nil)
+	case Snappy:
+		if size, err := snappy.DecodedLen(payload); err != nil {
+			return nil, err
+		} else if size > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed value exceeds %d bytes", maxDecompressedSize)
+		}
+		return snappy.Decode(nil, payload)
+	default:
+		return readLimited(lz4.NewReader(bytes.NewReader(payload)))
+	}
+}
+
+// readLimited reads a decompression stream, failing if the decompressed value exceeds maxDecompressedSize.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed value exceeds %d bytes", maxDecompressedSize)
+	}
+	return data, nil
+}
+
+// initZstd lazily creates the shared zstd encoder and decoder (both are safe for concurrent use).
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdError = zstd.NewWriter(nil); zstdError != nil {
+			return
+		}
+		zstdDecoder, zstdError = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdError
+}
@@ -4,19 +4,45 @@
 package facilities
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"sync"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	. "github.com/go-yaaf/yaaf-common/database"
 	. "github.com/go-yaaf/yaaf-common/entity"
 )
 
+// delPatternBatchSize is the number of keys scanned and unlinked per round trip by DelPattern
+const delPatternBatchSize = 500
+
+// ListEnd is the end of a list (head or tail) used by the list move operations
+type ListEnd string
+
+const (
+	ListLeft  ListEnd = "LEFT"  // The head of the list
+	ListRight ListEnd = "RIGHT" // The tail of the list
+)
+
 // region Key actions ----------------------------------------------------------------------------------------------
 
 // GetRaw gets the value of a key in a byte array format.
+// If a local cache is configured (see WithLocalCache), the value is served from the local cache when possible.
 func (r *RedisAdapter) GetRaw(key string) ([]byte, error) {
+	if r.local != nil {
+		return r.local.fetch(key, r.getRaw)
+	}
+	return r.getRaw(key)
+}
+
+// getRaw gets the value of a key from redis in a byte array format.
+func (r *RedisAdapter) getRaw(key string) ([]byte, error) {
 	var bytes []byte
-	cmd := r.rc.Get(r.ctx, key)
+	cmd := r.rc.Get(r.ctx, r.ns.key(key))
 	if err := cmd.Err(); err != nil {
 		return nil, err
 	} else {
@@ -33,22 +59,26 @@ func (r *RedisAdapter) Get(factory EntityFactory, key string) (Entity, error) {
 	if bytes, err := r.GetRaw(key); err != nil {
 		return nil, err
 	} else {
-		return rawToEntity(factory, bytes)
+		return r.serializer.rawToEntity(key, factory, bytes)
 	}
 }
 
 // SetRaw sets the value of a key from a byte array, with an optional expiration.
 func (r *RedisAdapter) SetRaw(key string, bytes []byte, expiration ...time.Duration) error {
+	var exp time.Duration = 0
 	if len(expiration) > 0 {
-		return r.rc.Set(r.ctx, key, bytes, expiration[0]).Err()
-	} else {
-		return r.rc.Set(r.ctx, key, bytes, 0).Err()
+		exp = expiration[0]
+	}
+	if err := r.rc.Set(r.ctx, r.ns.key(key), bytes, exp).Err(); err != nil {
+		return err
 	}
+	r.invalidateLocal(key)
+	return nil
 }
 
 // Set sets the value of a key from an entity, with an optional expiration.
 func (r *RedisAdapter) Set(key string, entity Entity, expiration ...time.Duration) error {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return err
 	} else {
 		return r.SetRaw(key, bytes, expiration...)
@@ -58,7 +88,7 @@ func (r *RedisAdapter) Set(key string, entity Entity, expiration ...time.Duratio
 // SetNX sets the value of a key from an entity only if the key does not already exist.
 // It returns false if the key exists. An optional expiration can be provided.
 func (r *RedisAdapter) SetNX(key string, entity Entity, expiration ...time.Duration) (bool, error) {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return false, err
 	} else {
 		return r.SetRawNX(key, bytes, expiration...)
@@ -72,28 +102,51 @@ func (r *RedisAdapter) SetRawNX(key string, bytes []byte, expiration ...time.Dur
 	if len(expiration) > 0 {
 		exp = expiration[0]
 	}
-	return r.rc.SetNX(r.ctx, key, bytes, exp).Result()
+	ok, err := r.rc.SetNX(r.ctx, r.ns.key(key), bytes, exp).Result()
+	if ok {
+		r.invalidateLocal(key)
+	}
+	return ok, err
 }
 
-// Del deletes one or more keys.
+// Del deletes one or more keys. On a Redis Cluster, the keys may belong to different hash slots.
 func (r *RedisAdapter) Del(keys ...string) error {
-	return r.rc.Del(r.ctx, keys...).Err()
+	if !r.isCluster() || len(keys) < 2 {
+		if err := r.rc.Del(r.ctx, r.ns.keys(keys)...).Err(); err != nil {
+			return err
+		}
+		r.invalidateLocal(keys...)
+		return nil
+	}
+	cmds, err := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(r.ctx, r.ns.key(key))
+		}
+		return nil
+	})
+	// Only the keys actually deleted are invalidated
+	deleted := make([]string, 0, len(keys))
+	for i, cmd := range cmds {
+		if cmd.Err() == nil {
+			deleted = append(deleted, keys[i])
+		}
+	}
+	if len(deleted) > 0 {
+		r.invalidateLocal(deleted...)
+	}
+	return err
 }
 
 // GetKeys gets the values of all the given keys as entities.
+// On a Redis Cluster, the keys may belong to different hash slots.
 func (r *RedisAdapter) GetKeys(factory EntityFactory, keys ...string) ([]Entity, error) {
-	cmd := r.rc.MGet(r.ctx, keys...)
-	if cmd.Err() != nil {
-		return nil, cmd.Err()
-	}
-
-	if list, err := cmd.Result(); err != nil {
+	if list, err := r.mget(r.ns.keys(keys)); err != nil {
 		return nil, err
 	} else {
 		entities := make([]Entity, 0)
-		for _, item := range list {
-			if item != nil {
-				if entity, err := rawToEntity(factory, item.([]byte)); err == nil {
+		for i, item := range list {
+			if str, ok := item.(string); ok {
+				if entity, err := r.serializer.rawToEntity(keys[i], factory, []byte(str)); err == nil {
 					entities = append(entities, entity)
 				}
 			}
@@ -103,19 +156,15 @@ func (r *RedisAdapter) GetKeys(factory EntityFactory, keys ...string) ([]Entity,
 }
 
 // GetRawKeys gets the raw values of all the given keys.
+// On a Redis Cluster, the keys may belong to different hash slots.
 func (r *RedisAdapter) GetRawKeys(keys ...string) ([]Tuple[string, []byte], error) {
-	cmd := r.rc.MGet(r.ctx, keys...)
-	if cmd.Err() != nil {
-		return nil, cmd.Err()
-	}
-
-	if list, err := cmd.Result(); err != nil {
+	if list, err := r.mget(r.ns.keys(keys)); err != nil {
 		return nil, err
 	} else {
 		tuples := make([]Tuple[string, []byte], 0)
 		for i, item := range list {
-			if item != nil {
-				tuple := Tuple[string, []byte]{Key: keys[i], Value: item.([]byte)}
+			if str, ok := item.(string); ok {
+				tuple := Tuple[string, []byte]{Key: keys[i], Value: []byte(str)}
 				tuples = append(tuples, tuple)
 			}
 		}
@@ -123,46 +172,201 @@ func (r *RedisAdapter) GetRawKeys(keys ...string) ([]Tuple[string, []byte], erro
 	}
 }
 
+// mget gets the values of keys (including the namespace) in a single round-trip, nil for missing keys.
+// On a Redis Cluster, MGET requires all the keys to belong to the same hash slot, so the keys are read
+// using a pipeline of GET commands instead (sent to every node holding some of the keys).
+func (r *RedisAdapter) mget(keys []string) ([]any, error) {
+	if !r.isCluster() {
+		return r.rc.MGet(r.ctx, keys...).Result()
+	}
+	cmds, _ := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Get(r.ctx, key)
+		}
+		return nil
+	})
+	values := make([]any, len(cmds))
+	for i, cmd := range cmds {
+		if value, err := cmd.(*redis.StringCmd).Result(); err == nil {
+			values[i] = value
+		} else if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
 // AddRaw sets the byte array value of a key only if the key does not exist.
 func (r *RedisAdapter) AddRaw(key string, bytes []byte, expiration time.Duration) (bool, error) {
-	if cmd := r.rc.SetNX(r.ctx, key, bytes, expiration); cmd.Err() != nil {
+	if cmd := r.rc.SetNX(r.ctx, r.ns.key(key), bytes, expiration); cmd.Err() != nil {
 		return false, cmd.Err()
 	} else {
+		if cmd.Val() {
+			r.invalidateLocal(key)
+		}
 		return cmd.Result()
 	}
 }
 
 // Add sets the value of a key from an entity only if the key does not exist.
 func (r *RedisAdapter) Add(key string, entity Entity, expiration time.Duration) (bool, error) {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return false, err
 	} else {
 		return r.AddRaw(key, bytes, expiration)
 	}
 }
 
-// Rename renames a key.
+// Rename renames a key. On a Redis Cluster, both keys must belong to the same hash slot (use a hash tag,
+// e.g. "{hero:1}:draft" and "{hero:1}:final").
 func (r *RedisAdapter) Rename(key string, newKey string) error {
-	return r.rc.Rename(r.ctx, key, newKey).Err()
+	if err := r.rc.Rename(r.ctx, r.ns.key(key), r.ns.key(newKey)).Err(); err != nil {
+		return err
+	}
+	r.invalidateLocal(key, newKey)
+	return nil
 }
 
 // Scan iterates through keys from the provided cursor that match a pattern.
 func (r *RedisAdapter) Scan(from uint64, match string, count int64) (keys []string, cursor uint64, err error) {
-	scanCmd := r.rc.Scan(r.ctx, from, match, count)
+	scanCmd := r.rc.Scan(r.ctx, from, r.ns.pattern(match), count)
 	if err = scanCmd.Err(); err != nil {
 		return nil, 0, err
 	} else {
 		if list, cur, er := scanCmd.Result(); er != nil {
 			return nil, 0, er
 		} else {
+			for i := range list {
+				list[i] = r.ns.strip(list[i])
+			}
 			return list, cur, nil
 		}
 	}
 }
 
+// ScanKeys returns an iterator over all the keys matching a pattern (use "" to match all keys).
+// An optional key type (e.g. "string", "hash", "list") filters the keys by their type. The internal keys of the
+// adapter (prefixed by "yaaf:", e.g. tag indexes and load locks) are skipped, unless the pattern starts with "yaaf:".
+// The count is a hint for the number of keys fetched per SCAN round trip (0 means the redis default).
+// On a Redis Cluster, the keyspace of every master node is iterated.
+// As per the SCAN guarantees, a key may be returned more than once, so callers should be idempotent.
+//
+//	for key, err := range adapter.ScanKeys("hero:*", "string", 100) {
+//		if err != nil {
+//			// Handle error (the iteration stops after an error)
+//		}
+//		fmt.Println(key)
+//	}
+func (r *RedisAdapter) ScanKeys(match string, keyType string, count int64) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		nodes, err := r.scanNodes()
+		if err != nil {
+			yield("", err)
+			return
+		}
+		for _, node := range nodes {
+			var cursor uint64
+			for {
+				var keys []string
+				if keyType == "" {
+					keys, cursor, err = node.Scan(r.ctx, cursor, r.ns.pattern(match), count).Result()
+				} else {
+					keys, cursor, err = node.ScanType(r.ctx, cursor, r.ns.pattern(match), count, keyType).Result()
+				}
+				if err != nil {
+					yield("", err)
+					return
+				}
+				for _, key := range keys {
+					if key = r.ns.strip(key); isReserved(key, match) {
+						continue
+					}
+					if !yield(key, nil) {
+						return
+					}
+				}
+				if cursor == 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DelPattern deletes all the keys matching a pattern, using SCAN and UNLINK in batches
+// (the memory is reclaimed in the background by redis). It returns the number of deleted keys.
+// Like ScanKeys, the internal keys of the adapter are skipped unless the pattern starts with "yaaf:".
+func (r *RedisAdapter) DelPattern(match string) (int64, error) {
+	var deleted int64
+	batch := make([]string, 0, delPatternBatchSize)
+
+	unlink := func() error {
+		cmds, err := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range batch {
+				pipe.Unlink(r.ctx, r.ns.key(key))
+			}
+			return nil
+		})
+		// Only the keys actually unlinked are invalidated
+		unlinked := make([]string, 0, len(batch))
+		for i, cmd := range cmds {
+			deleted += cmd.(*redis.IntCmd).Val()
+			if cmd.Err() == nil {
+				unlinked = append(unlinked, batch[i])
+			}
+		}
+		if len(unlinked) > 0 {
+			r.invalidateLocal(unlinked...)
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	for key, err := range r.ScanKeys(match, "", delPatternBatchSize) {
+		if err != nil {
+			return deleted, err
+		}
+		if batch = append(batch, key); len(batch) == delPatternBatchSize {
+			if err = unlink(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := unlink(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// isCluster checks if the adapter is connected to a Redis Cluster.
+func (r *RedisAdapter) isCluster() bool {
+	_, ok := r.rc.(*redis.ClusterClient)
+	return ok
+}
+
+// scanNodes returns the nodes holding the keyspace: all the master nodes of a cluster, or the single redis server.
+func (r *RedisAdapter) scanNodes() ([]redis.Cmdable, error) {
+	cluster, ok := r.rc.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{r.rc}, nil
+	}
+
+	var mu sync.Mutex
+	nodes := make([]redis.Cmdable, 0)
+	err := cluster.ForEachMaster(r.ctx, func(ctx context.Context, client *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, client)
+		return nil
+	})
+	return nodes, err
+}
+
 // Exists checks if a key exists.
 func (r *RedisAdapter) Exists(key string) (result bool, err error) {
-	if cmd := r.rc.Exists(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.Exists(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return false, cmd.Err()
 	} else {
 		return cmd.Val() > 0, nil
@@ -178,13 +382,13 @@ func (r *RedisAdapter) HGet(factory EntityFactory, key, field string) (Entity, e
 	if bytes, err := r.HGetRaw(key, field); err != nil {
 		return nil, err
 	} else {
-		return rawToEntity(factory, bytes)
+		return r.serializer.rawToEntity(key, factory, bytes)
 	}
 }
 
 // HGetRaw gets the raw value of a hash field as a byte array.
 func (r *RedisAdapter) HGetRaw(key, field string) ([]byte, error) {
-	cmd := r.rc.HGet(r.ctx, key, field)
+	cmd := r.rc.HGet(r.ctx, r.ns.key(key), field)
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
@@ -198,7 +402,7 @@ func (r *RedisAdapter) HGetRaw(key, field string) ([]byte, error) {
 
 // HKeys gets all the fields in a hash.
 func (r *RedisAdapter) HKeys(key string) ([]string, error) {
-	if cmd := r.rc.HKeys(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.HKeys(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
 		return cmd.Val(), nil
@@ -206,23 +410,30 @@ func (r *RedisAdapter) HKeys(key string) ([]string, error) {
 }
 
 // HGetAll gets all the fields and values in a hash and decodes them into entities.
+// Fields that fail to decode are reported by a DecodeError, along with the fields that were decoded successfully.
 func (r *RedisAdapter) HGetAll(factory EntityFactory, key string) (map[string]Entity, error) {
-	if cmd := r.rc.HGetAll(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.HGetAll(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
 		result := make(map[string]Entity)
+		failed := make(map[string]error)
 		for k, str := range cmd.Val() {
-			if entity, er := rawToEntity(factory, []byte(str)); er == nil {
+			if entity, er := r.serializer.rawToEntity(key, factory, []byte(str)); er != nil {
+				failed[k] = er
+			} else {
 				result[k] = entity
 			}
 		}
+		if len(failed) > 0 {
+			return result, &DecodeError{Key: key, Failed: failed}
+		}
 		return result, nil
 	}
 }
 
 // HGetRawAll gets all the fields and their raw values in a hash.
 func (r *RedisAdapter) HGetRawAll(key string) (map[string][]byte, error) {
-	if cmd := r.rc.HGetAll(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.HGetAll(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
 		result := make(map[string][]byte)
@@ -233,48 +444,146 @@ func (r *RedisAdapter) HGetRawAll(key string) (map[string][]byte, error) {
 	}
 }
 
+// HMGet gets the values of the given hash fields and decodes them into entities.
+// Fields that do not exist are omitted from the result. Fields that fail to decode are reported by a DecodeError,
+// along with the fields that were decoded successfully.
+func (r *RedisAdapter) HMGet(factory EntityFactory, key string, fields ...string) (map[string]Entity, error) {
+	raw, err := r.HMGetRaw(key, fields...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]Entity)
+	failed := make(map[string]error)
+	for field, bytes := range raw {
+		if entity, er := r.serializer.rawToEntity(key, factory, bytes); er != nil {
+			failed[field] = er
+		} else {
+			result[field] = entity
+		}
+	}
+	if len(failed) > 0 {
+		return result, &DecodeError{Key: key, Failed: failed}
+	}
+	return result, nil
+}
+
+// HMGetRaw gets the raw values of the given hash fields. Fields that do not exist are omitted from the result.
+func (r *RedisAdapter) HMGetRaw(key string, fields ...string) (map[string][]byte, error) {
+	if cmd := r.rc.HMGet(r.ctx, r.ns.key(key), fields...); cmd.Err() != nil {
+		return nil, cmd.Err()
+	} else {
+		result := make(map[string][]byte)
+		for i, item := range cmd.Val() {
+			if str, ok := item.(string); ok {
+				result[fields[i]] = []byte(str)
+			}
+		}
+		return result, nil
+	}
+}
+
+// HScan returns an iterator over the fields of a hash matching a pattern (use "" to match all fields),
+// decoding their values into entities. Unlike HGetAll, the hash is fetched in chunks (the count is a hint
+// for the number of fields per HSCAN round trip), so large hashes are never loaded into memory at once.
+// A field that fails to decode is yielded with a DecodeError and the iteration continues,
+// any other error stops the iteration.
+func (r *RedisAdapter) HScan(factory EntityFactory, key, match string, count int64) iter.Seq2[Tuple[string, Entity], error] {
+	return func(yield func(Tuple[string, Entity], error) bool) {
+		for item, err := range r.HScanRaw(key, match, count) {
+			if err != nil {
+				yield(Tuple[string, Entity]{Key: item.Key}, err)
+				return
+			}
+			entity, er := r.serializer.rawToEntity(key, factory, item.Value)
+			if er != nil {
+				if !yield(Tuple[string, Entity]{Key: item.Key}, newDecodeError(key, item.Key, er)) {
+					return
+				}
+				continue
+			}
+			if !yield(Tuple[string, Entity]{Key: item.Key, Value: entity}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// HScanRaw returns an iterator over the fields of a hash matching a pattern (use "" to match all fields)
+// and their raw values. As per the HSCAN guarantees, a field may be returned more than once.
+func (r *RedisAdapter) HScanRaw(key, match string, count int64) iter.Seq2[Tuple[string, []byte], error] {
+	return func(yield func(Tuple[string, []byte], error) bool) {
+		var cursor uint64
+		for {
+			list, next, err := r.rc.HScan(r.ctx, r.ns.key(key), cursor, match, count).Result()
+			if err != nil {
+				yield(Tuple[string, []byte]{}, err)
+				return
+			}
+			// HSCAN returns a flat list of field and value pairs
+			for i := 0; i+1 < len(list); i += 2 {
+				if !yield(Tuple[string, []byte]{Key: list[i], Value: []byte(list[i+1])}, nil) {
+					return
+				}
+			}
+			if cursor = next; cursor == 0 {
+				return
+			}
+		}
+	}
+}
+
+// HLen gets the number of fields in a hash.
+func (r *RedisAdapter) HLen(key string) (int64, error) {
+	return r.rc.HLen(r.ctx, r.ns.key(key)).Result()
+}
+
+// HStrLen gets the length of the raw value of a hash field (0 if the field or the hash does not exist).
+func (r *RedisAdapter) HStrLen(key, field string) (int64, error) {
+	return r.rc.HStrLen(r.ctx, r.ns.key(key), field).Result()
+}
+
 // HSet sets the value of a hash field from an entity.
 func (r *RedisAdapter) HSet(key, field string, entity Entity) error {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return err
 	} else {
-		return r.rc.HSet(r.ctx, key, field, bytes).Err()
+		return r.rc.HSet(r.ctx, r.ns.key(key), field, bytes).Err()
 	}
 }
 
 // HSetRaw sets the raw value of a hash field from a byte array.
 func (r *RedisAdapter) HSetRaw(key, field string, bytes []byte) error {
-	return r.rc.HSet(r.ctx, key, field, bytes).Err()
+	return r.rc.HSet(r.ctx, r.ns.key(key), field, bytes).Err()
 }
 
 // HSetNX sets the value of a hash field from an entity only if the field does not already exist.
 // Returns false if the field already exists.
 func (r *RedisAdapter) HSetNX(key string, field string, entity Entity) (bool, error) {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return false, err
 	} else {
-		return r.rc.HSetNX(r.ctx, key, field, bytes).Result()
+		return r.rc.HSetNX(r.ctx, r.ns.key(key), field, bytes).Result()
 	}
 }
 
 // HSetRawNX sets the raw value of a hash field from a byte array only if the field does not already exist.
 // Returns false if the field already exists.
 func (r *RedisAdapter) HSetRawNX(key string, field string, bytes []byte) (bool, error) {
-	return r.rc.HSetNX(r.ctx, key, field, bytes).Result()
+	return r.rc.HSetNX(r.ctx, r.ns.key(key), field, bytes).Result()
 }
 
 // HDel deletes one or more hash fields.
 func (r *RedisAdapter) HDel(key string, fields ...string) error {
-	return r.rc.HDel(r.ctx, key, fields...).Err()
+	return r.rc.HDel(r.ctx, r.ns.key(key), fields...).Err()
 }
 
 // HAdd sets the value of a hash field from an entity only if the field does not exist.
 // This is an alias for HSetNX.
 func (r *RedisAdapter) HAdd(key, field string, entity Entity) (bool, error) {
-	if bytes, err := entityToRaw(entity); err != nil {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
 		return false, err
 	} else {
-		if err = r.rc.HSetNX(r.ctx, key, field, bytes).Err(); err != nil {
+		if err = r.rc.HSetNX(r.ctx, r.ns.key(key), field, bytes).Err(); err != nil {
 			return false, err
 		} else {
 			return true, nil
@@ -285,7 +594,7 @@ func (r *RedisAdapter) HAdd(key, field string, entity Entity) (bool, error) {
 // HAddRaw sets the raw value of a hash field from a byte array only if the field does not exist.
 // This is an alias for HSetRawNX.
 func (r *RedisAdapter) HAddRaw(key, field string, bytes []byte) (bool, error) {
-	if err := r.rc.HSetNX(r.ctx, key, field, bytes).Err(); err != nil {
+	if err := r.rc.HSetNX(r.ctx, r.ns.key(key), field, bytes).Err(); err != nil {
 		return false, err
 	} else {
 		return true, nil
@@ -294,7 +603,7 @@ func (r *RedisAdapter) HAddRaw(key, field string, bytes []byte) (bool, error) {
 
 // HExists checks if a field exists in a hash.
 func (r *RedisAdapter) HExists(key, field string) (bool, error) {
-	if cmd := r.rc.HExists(r.ctx, key, field); cmd.Err() != nil {
+	if cmd := r.rc.HExists(r.ctx, r.ns.key(key), field); cmd.Err() != nil {
 		return false, cmd.Err()
 	} else {
 		return cmd.Val(), nil
@@ -306,70 +615,94 @@ func (r *RedisAdapter) HExists(key, field string) (bool, error) {
 // region List actions ---------------------------------------------------------------------------------------------
 
 // RPush appends one or multiple values to a list.
+// If any of the values fails to marshal, nothing is pushed and the error is returned.
 func (r *RedisAdapter) RPush(key string, value ...Entity) error {
-	values := make([]any, 0)
-	for _, v := range value {
-		if bytes, err := entityToRaw(v); err == nil {
-			values = append(values, bytes)
-		}
+	if values, err := r.serializer.entitiesToRaw(key, value); err != nil {
+		return err
+	} else {
+		return r.RPushRaw(key, values...)
 	}
-	if len(values) > 0 {
-		return r.rc.RPush(r.ctx, key, values...).Err()
+}
+
+// RPushRaw appends one or multiple raw values to a list.
+func (r *RedisAdapter) RPushRaw(key string, value ...[]byte) error {
+	if len(value) == 0 {
+		return nil
 	}
-	return nil
+	return r.rc.RPush(r.ctx, r.ns.key(key), rawToAny(value)...).Err()
 }
 
 // LPush prepends one or multiple values to a list.
+// If any of the values fails to marshal, nothing is pushed and the error is returned.
 func (r *RedisAdapter) LPush(key string, value ...Entity) error {
-	values := make([]any, 0)
-	for _, v := range value {
-		if bytes, err := entityToRaw(v); err == nil {
-			values = append(values, bytes)
-		}
+	if values, err := r.serializer.entitiesToRaw(key, value); err != nil {
+		return err
+	} else {
+		return r.LPushRaw(key, values...)
 	}
-	if len(values) > 0 {
-		return r.rc.LPush(r.ctx, key, values...).Err()
+}
+
+// LPushRaw prepends one or multiple raw values to a list.
+func (r *RedisAdapter) LPushRaw(key string, value ...[]byte) error {
+	if len(value) == 0 {
+		return nil
 	}
-	return nil
+	return r.rc.LPush(r.ctx, r.ns.key(key), rawToAny(value)...).Err()
+}
+
+// LPushCapped prepends one or multiple values to a list and trims the list to its newest maxLen elements,
+// in a single transaction. This is the typical way to maintain a capped list (e.g. an activity feed).
+func (r *RedisAdapter) LPushCapped(key string, maxLen int64, value ...Entity) error {
+	values, err := r.serializer.entitiesToRaw(key, value)
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	_, err = r.rc.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(r.ctx, r.ns.key(key), rawToAny(values)...)
+		pipe.LTrim(r.ctx, r.ns.key(key), 0, maxLen-1)
+		return nil
+	})
+	return err
 }
 
 // RPop removes and gets the last element in a list.
 func (r *RedisAdapter) RPop(factory EntityFactory, key string) (Entity, error) {
-	if cmd := r.rc.RPop(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.RPop(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
 		if bytes, err := cmd.Bytes(); err != nil {
 			return nil, err
 		} else {
-			return rawToEntity(factory, bytes)
+			return r.serializer.rawToEntity(key, factory, bytes)
 		}
 	}
 }
 
 // LPop removes and gets the first element in a list.
 func (r *RedisAdapter) LPop(factory EntityFactory, key string) (entity Entity, err error) {
-	if cmd := r.rc.LPop(r.ctx, key); cmd.Err() != nil {
+	if cmd := r.rc.LPop(r.ctx, r.ns.key(key)); cmd.Err() != nil {
 		return nil, cmd.Err()
 	} else {
 		if bytes, er := cmd.Bytes(); er != nil {
 			return nil, er
 		} else {
-			return rawToEntity(factory, bytes)
+			return r.serializer.rawToEntity(key, factory, bytes)
 		}
 	}
 }
 
 // BRPop is a blocking version of RPop. It removes and gets the last element in a list,
 // or blocks until one is available or the timeout is reached.
+// On a Redis Cluster, all the keys must belong to the same hash slot (use a hash tag).
 func (r *RedisAdapter) BRPop(factory EntityFactory, timeout time.Duration, keys ...string) (key string, entity Entity, err error) {
-	if cmd := r.rc.BRPop(r.ctx, timeout, keys...); cmd.Err() != nil {
+	if cmd := r.rc.BRPop(r.ctx, timeout, r.ns.keys(keys)...); cmd.Err() != nil {
 		return "", nil, cmd.Err()
 	} else {
 		if result, er := cmd.Result(); er != nil {
 			return "", nil, er
 		} else {
-			key = result[0]
-			entity, err = rawToEntity(factory, []byte(result[1]))
+			key = r.ns.strip(result[0])
+			entity, err = r.serializer.rawToEntity(key, factory, []byte(result[1]))
 			return
 		}
 	}
@@ -377,28 +710,30 @@ func (r *RedisAdapter) BRPop(factory EntityFactory, timeout time.Duration, keys
 
 // BLPop is a blocking version of LPop. It removes and gets the first element in a list,
 // or blocks until one is available or the timeout is reached.
+// On a Redis Cluster, all the keys must belong to the same hash slot (use a hash tag).
 func (r *RedisAdapter) BLPop(factory EntityFactory, timeout time.Duration, keys ...string) (key string, entity Entity, err error) {
-	if cmd := r.rc.BLPop(r.ctx, timeout, keys...); cmd.Err() != nil {
+	if cmd := r.rc.BLPop(r.ctx, timeout, r.ns.keys(keys)...); cmd.Err() != nil {
 		return "", nil, cmd.Err()
 	} else {
 		if result, er := cmd.Result(); er != nil {
 			return "", nil, er
 		} else {
-			key = result[0]
-			entity, err = rawToEntity(factory, []byte(result[1]))
+			key = r.ns.strip(result[0])
+			entity, err = r.serializer.rawToEntity(key, factory, []byte(result[1]))
 			return
 		}
 	}
 }
 
-// LRange gets a range of elements from a list.
+// LRange gets a range of elements from a list. Elements that fail to decode are skipped
+// (use LRangeRaw to read them).
 func (r *RedisAdapter) LRange(factory EntityFactory, key string, start, stop int64) ([]Entity, error) {
-	if list, err := r.rc.LRange(r.ctx, key, start, stop).Result(); err != nil {
+	if list, err := r.rc.LRange(r.ctx, r.ns.key(key), start, stop).Result(); err != nil {
 		return nil, err
 	} else {
 		result := make([]Entity, 0)
 		for _, str := range list {
-			if entity, err := rawToEntity(factory, []byte(str)); err == nil {
+			if entity, er := r.serializer.rawToEntity(key, factory, []byte(str)); er == nil {
 				result = append(result, entity)
 			}
 		}
@@ -406,9 +741,141 @@ func (r *RedisAdapter) LRange(factory EntityFactory, key string, start, stop int
 	}
 }
 
+// LRangeRaw gets a range of raw elements from a list.
+func (r *RedisAdapter) LRangeRaw(key string, start, stop int64) ([][]byte, error) {
+	if list, err := r.rc.LRange(r.ctx, r.ns.key(key), start, stop).Result(); err != nil {
+		return nil, err
+	} else {
+		result := make([][]byte, 0, len(list))
+		for _, str := range list {
+			result = append(result, []byte(str))
+		}
+		return result, nil
+	}
+}
+
 // LLen gets the length of a list.
 func (r *RedisAdapter) LLen(key string) (result int64) {
-	return r.rc.LLen(r.ctx, key).Val()
+	return r.rc.LLen(r.ctx, r.ns.key(key)).Val()
+}
+
+// LIndex gets an element from a list by its index (negative indexes count from the tail, -1 is the last element).
+func (r *RedisAdapter) LIndex(factory EntityFactory, key string, index int64) (Entity, error) {
+	if bytes, err := r.LIndexRaw(key, index); err != nil {
+		return nil, err
+	} else {
+		return r.serializer.rawToEntity(key, factory, bytes)
+	}
+}
+
+// LIndexRaw gets a raw element from a list by its index.
+func (r *RedisAdapter) LIndexRaw(key string, index int64) ([]byte, error) {
+	return r.rc.LIndex(r.ctx, r.ns.key(key), index).Bytes()
+}
+
+// LPos gets the index of the first element of a list equal to the given entity.
+// It returns redis.Nil if the element is not found, and ErrEncryptedComparison for encrypted keys.
+func (r *RedisAdapter) LPos(key string, entity Entity) (int64, error) {
+	if bytes, err := r.serializer.entityToComparableRaw(key, entity); err != nil {
+		return -1, err
+	} else {
+		return r.LPosRaw(key, bytes)
+	}
+}
+
+// LPosRaw gets the index of the first element of a list equal to the given raw value.
+// It returns redis.Nil if the element is not found.
+func (r *RedisAdapter) LPosRaw(key string, bytes []byte) (int64, error) {
+	return r.rc.LPos(r.ctx, r.ns.key(key), string(bytes), redis.LPosArgs{}).Result()
+}
+
+// LSet sets the value of a list element by its index.
+func (r *RedisAdapter) LSet(key string, index int64, entity Entity) error {
+	if bytes, err := r.serializer.entityToRaw(key, entity); err != nil {
+		return err
+	} else {
+		return r.LSetRaw(key, index, bytes)
+	}
+}
+
+// LSetRaw sets the raw value of a list element by its index.
+func (r *RedisAdapter) LSetRaw(key string, index int64, bytes []byte) error {
+	return r.rc.LSet(r.ctx, r.ns.key(key), index, bytes).Err()
+}
+
+// LInsert inserts an entity before or after the first element equal to the pivot entity.
+// It returns the length of the list after the insert, or -1 if the pivot was not found,
+// and ErrEncryptedComparison for encrypted keys.
+func (r *RedisAdapter) LInsert(key string, before bool, pivot, entity Entity) (int64, error) {
+	if pivotBytes, err := r.serializer.entityToComparableRaw(key, pivot); err != nil {
+		return 0, err
+	} else if bytes, er := r.serializer.entityToRaw(key, entity); er != nil {
+		return 0, er
+	} else {
+		return r.LInsertRaw(key, before, pivotBytes, bytes)
+	}
+}
+
+// LInsertRaw inserts a raw value before or after the first element equal to the raw pivot value.
+// It returns the length of the list after the insert, or -1 if the pivot was not found.
+func (r *RedisAdapter) LInsertRaw(key string, before bool, pivot, bytes []byte) (int64, error) {
+	op := "AFTER"
+	if before {
+		op = "BEFORE"
+	}
+	return r.rc.LInsert(r.ctx, r.ns.key(key), op, pivot, bytes).Result()
+}
+
+// LTrim trims a list so that it contains only the elements in the specified range (inclusive).
+func (r *RedisAdapter) LTrim(key string, start, stop int64) error {
+	return r.rc.LTrim(r.ctx, r.ns.key(key), start, stop).Err()
+}
+
+// LRem removes elements equal to the given entity from a list: count > 0 removes the first count elements
+// from head to tail, count < 0 removes from tail to head, and 0 removes them all.
+// It returns the number of removed elements, and ErrEncryptedComparison for encrypted keys.
+func (r *RedisAdapter) LRem(key string, count int64, entity Entity) (int64, error) {
+	if bytes, err := r.serializer.entityToComparableRaw(key, entity); err != nil {
+		return 0, err
+	} else {
+		return r.LRemRaw(key, count, bytes)
+	}
+}
+
+// LRemRaw removes elements equal to the given raw value from a list (see LRem).
+func (r *RedisAdapter) LRemRaw(key string, count int64, bytes []byte) (int64, error) {
+	return r.rc.LRem(r.ctx, r.ns.key(key), count, bytes).Result()
+}
+
+// LMove atomically removes an element from one end of the source list and pushes it to one end of the destination list.
+// It returns the moved element, or redis.Nil if the source list is empty.
+// On a Redis Cluster, both lists must belong to the same hash slot (use a hash tag).
+func (r *RedisAdapter) LMove(factory EntityFactory, source, destination string, from, to ListEnd) (Entity, error) {
+	if bytes, err := r.LMoveRaw(source, destination, from, to); err != nil {
+		return nil, err
+	} else {
+		return r.serializer.rawToEntity(source, factory, bytes)
+	}
+}
+
+// LMoveRaw atomically moves a raw element from the source list to the destination list (see LMove).
+func (r *RedisAdapter) LMoveRaw(source, destination string, from, to ListEnd) ([]byte, error) {
+	return r.rc.LMove(r.ctx, r.ns.key(source), r.ns.key(destination), string(from), string(to)).Bytes()
+}
+
+// BLMove is a blocking version of LMove. It blocks until an element is available in the source list
+// or the timeout is reached (0 blocks indefinitely). On a Redis Cluster, both lists must belong to the same hash slot.
+func (r *RedisAdapter) BLMove(factory EntityFactory, source, destination string, from, to ListEnd, timeout time.Duration) (Entity, error) {
+	if bytes, err := r.BLMoveRaw(source, destination, from, to, timeout); err != nil {
+		return nil, err
+	} else {
+		return r.serializer.rawToEntity(source, factory, bytes)
+	}
+}
+
+// BLMoveRaw is a blocking version of LMoveRaw.
+func (r *RedisAdapter) BLMoveRaw(source, destination string, from, to ListEnd, timeout time.Duration) ([]byte, error) {
+	return r.rc.BLMove(r.ctx, r.ns.key(source), r.ns.key(destination), string(from), string(to), timeout).Bytes()
 }
 
 // endregion
@@ -418,19 +885,30 @@ func (r *RedisAdapter) LLen(key string) (result int64) {
 // ObtainLocker tries to obtain a new lock using a key with a given TTL.
 // It returns an ILocker instance if the lock is obtained, or an error otherwise.
 func (r *RedisAdapter) ObtainLocker(key string, ttl time.Duration) (ILocker, error) {
+	if locker, err := r.tryLock(key, ttl); err != nil {
+		return nil, err
+	} else if locker == nil {
+		return nil, fmt.Errorf("locker key already exists")
+	} else {
+		return locker, nil
+	}
+}
+
+// tryLock tries to obtain a lock using a key with a given TTL.
+// It returns a nil Locker (and no error) if the lock is already held by someone else.
+func (r *RedisAdapter) tryLock(key string, ttl time.Duration) (*Locker, error) {
 	// Create a cryptographically strong, unguessable token.
 	// The token authenticates lock ownership in the Release/Refresh/TTL Lua scripts,
 	// so it must be unpredictable (a timestamp-based ID would be forgeable).
 	token := GUID()
 
-	if ok, err := r.SetRawNX(key, []byte(token), ttl); err != nil {
+	// Lock keys are never read through the local cache, so acquiring a lock publishes no invalidation
+	if ok, err := r.rc.SetNX(r.ctx, r.ns.key(key), token, ttl).Result(); err != nil {
 		return nil, err
+	} else if !ok {
+		return nil, nil
 	} else {
-		if ok {
-			return &Locker{rc: r.rc, key: key, token: token}, nil
-		} else {
-			return nil, fmt.Errorf("locker key already exists")
-		}
+		return &Locker{rc: r.rc, key: r.ns.key(key), token: token}, nil
 	}
 }
 
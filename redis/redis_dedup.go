@@ -0,0 +1,77 @@
+// Message deduplication (idempotent consumption) for the redis implementation of IMessageBus
+//
+
+package facilities
+
+import (
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/go-yaaf/yaaf-common/logger"
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// dedupPrefix is the prefix of the keys marking processed message ids
+const dedupPrefix = "yaaf:dedup:"
+
+// deduplication holds the deduplication configuration (see WithDeduplication).
+type deduplication struct {
+	window time.Duration  // the time a processed message id is remembered
+	id     MessageKeyFunc // the id of a message
+}
+
+// WithDeduplication skips the messages already processed within the window: messages received by subscriptions
+// (per subscriber name), consumers (per subscription name) and Pop (per queue). A message id is marked as processed
+// atomically (using SET NX) before the message is processed, so even concurrent subscribers or consumers never both
+// process the same id, and a message whose processing fails is not redelivered within the window. The id function
+// is required (creating the adapter fails without it, or with a non-positive window) and must return an id unique to every message, stable across
+// retries (e.g. a business id): the session id, for instance, is shared by distinct messages. Messages without an id
+// are never skipped.
+func WithDeduplication(window time.Duration, id MessageKeyFunc) Option {
+	return func(r *RedisAdapter) {
+		r.dedup = &deduplication{window: window, id: id}
+	}
+}
+
+// ErrDeduplicationId is returned when creating an adapter with deduplication and no message id function.
+var ErrDeduplicationId = errors.New("deduplication requires a message id function")
+
+// ErrDeduplicationWindow is returned when creating an adapter with deduplication and a non-positive window.
+var ErrDeduplicationWindow = errors.New("deduplication requires a positive window")
+
+// isDuplicate marks the id of a message as processed in a scope, and checks if it was already processed.
+// If redis fails, the message is not considered a duplicate.
+func (r *RedisAdapter) isDuplicate(scope string, message IMessage) bool {
+	if r.dedup == nil {
+		return false
+	}
+	id := r.dedup.id(message)
+	if id == "" {
+		return false
+	}
+	if err := r.rc.SetArgs(r.ctx, r.ns.key(dedupPrefix+scope+":"+id), 1, redis.SetArgs{Mode: "NX", TTL: r.dedup.window}).Err(); err == nil {
+		return false
+	} else if errors.Is(err, redis.Nil) {
+		return true
+	} else {
+		logger.Warn("Deduplication: failed to mark message %s as processed: %s", id, err.Error())
+		return false
+	}
+}
+
+// dedupMiddlewares returns the middleware skipping duplicate messages in a scope, or none if deduplication is disabled.
+func (r *RedisAdapter) dedupMiddlewares(scope string) []SubscriptionMiddleware {
+	if r.dedup == nil {
+		return nil
+	}
+	return []SubscriptionMiddleware{func(next SubscriptionCallback) SubscriptionCallback {
+		return func(message IMessage) bool {
+			if r.isDuplicate(scope, message) {
+				return false
+			}
+			return next(message)
+		}
+	}}
+}
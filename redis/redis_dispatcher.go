@@ -0,0 +1,273 @@
+// Concurrency, ordering and overflow control of subscription callbacks
+//
+
+package facilities
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// maxConcurrentHandlers bounds the number of message-callback goroutines a single
+// subscription may run concurrently. Without a bound, a high-volume or malicious
+// publisher could spawn unbounded goroutines and exhaust memory (resource-exhaustion DoS).
+// It is the default concurrency of a subscription (see WithConcurrency).
+const maxConcurrentHandlers = 256
+
+// OrderingMode defines the order in which the callbacks of a subscription process the messages.
+type OrderingMode int
+
+const (
+	OrderNone       OrderingMode = iota // Messages are processed concurrently, in any order (default)
+	OrderSequential                     // Messages are processed one at a time, in the order they were received
+	OrderByKey                          // Messages with the same key are processed one at a time, in order (see WithOrderingKey)
+)
+
+// OverflowPolicy defines what happens to a received message when all the workers of a subscription are busy
+// and its pending buffer is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // Stop receiving until a worker is available (back-pressure, default)
+	OverflowDropOldest                       // Drop the oldest pending message to make room for the new one (requires a buffer)
+	OverflowDropNewest                       // Drop the new message
+)
+
+// MessageKeyFunc returns the ordering key of a message (see WithOrderingKey).
+type MessageKeyFunc func(message IMessage) string
+
+// WithConcurrency sets the maximum number of callbacks of the subscription running concurrently
+// (256 by default). With OrderByKey, it is the number of workers the keys are distributed to.
+func WithConcurrency(workers int) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		if workers > 0 {
+			o.concurrency = workers
+		}
+	}
+}
+
+// WithOrdering sets the order in which the messages are processed. With OrderByKey, the messages are
+// ordered by their session id unless an ordering key is provided (see WithOrderingKey).
+func WithOrdering(mode OrderingMode) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.ordering = mode
+	}
+}
+
+// WithOrderingKey processes the messages with the same key (e.g. an entity id) one at a time, in the order they
+// were received, while messages with different keys are processed concurrently. It implies OrderByKey.
+func WithOrderingKey(key MessageKeyFunc) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.ordering = OrderByKey
+		o.orderingKey = key
+	}
+}
+
+// WithOverflow sets the number of received messages waiting for a worker, and the policy applied when this buffer is full
+// (by default there is no buffer, and receiving blocks until a worker is available). With OrderByKey, every worker
+// has its own buffer. OverflowDropOldest requires a buffer (a bufferSize of at least 1): without pending messages
+// there is nothing older to drop, so subscribing fails.
+func WithOverflow(policy OverflowPolicy, bufferSize int) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.overflow = policy
+		o.bufferSize = max(bufferSize, 0)
+	}
+}
+
+// ErrInvalidOverflow is returned when subscribing with OverflowDropOldest and no pending buffer (see WithOverflow).
+var ErrInvalidOverflow = errors.New("OverflowDropOldest requires a pending buffer")
+
+// validate checks the consistency of the subscription options.
+func (o subscriptionOptions) validate() error {
+	if o.overflow == OverflowDropOldest && o.bufferSize == 0 {
+		return ErrInvalidOverflow
+	}
+	return nil
+}
+
+// messageKey returns the ordering key of a message.
+func (o *subscriptionOptions) messageKey(message IMessage) string {
+	if o.ordering != OrderByKey {
+		return ""
+	}
+	if o.orderingKey != nil {
+		return o.orderingKey(message)
+	}
+	return message.SessionId()
+}
+
+// region Dispatcher ---------------------------------------------------------------------------------------------------
+
+// dispatcher runs the callbacks of a subscription on bounded goroutines (see WithConcurrency), organized in lanes:
+// a single lane of concurrent workers, or one sequential lane per worker when messages are ordered by key.
+type dispatcher struct {
+	lanes    []*lane
+	inFlight *handlerGroup // the in-flight callbacks of all the subscriptions of the adapter
+	dropped  atomic.Int64  // the number of messages dropped by the overflow policy
+}
+
+// newDispatcher creates a dispatcher for a subscription.
+func newDispatcher(inFlight *handlerGroup, options subscriptionOptions) *dispatcher {
+	d := &dispatcher{inFlight: inFlight}
+	workers := max(options.concurrency, 1)
+	switch options.ordering {
+	case OrderSequential:
+		d.lanes = []*lane{newLane(1, options)}
+	case OrderByKey:
+		for i := 0; i < workers; i++ {
+			d.lanes = append(d.lanes, newLane(1, options))
+		}
+	default:
+		d.lanes = []*lane{newLane(workers, options)}
+	}
+	return d
+}
+
+// dispatch runs the handler on the lane of the key. It blocks (applying back-pressure) once the bound is reached,
+// unless the overflow policy drops messages.
+func (d *dispatcher) dispatch(key string, handler func()) {
+	l := d.lanes[0]
+	if len(d.lanes) > 1 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		l = d.lanes[h.Sum32()%uint32(len(d.lanes))]
+	}
+
+	d.inFlight.add()
+	task := func() {
+		defer d.inFlight.done()
+		handler()
+	}
+	if l.push(task) {
+		// Either the new message or the oldest pending one was dropped
+		d.dropped.Add(1)
+		d.inFlight.done()
+	}
+}
+
+// lane runs tasks on up to a number of workers, with a bounded buffer of pending tasks.
+// A lane with a single worker runs its tasks in order.
+type lane struct {
+	mu       sync.Mutex
+	space    *sync.Cond // signaled when a worker is available or a pending task is taken
+	workers  int
+	running  int
+	pending  []func()
+	capacity int
+	policy   OverflowPolicy
+}
+
+// newLane creates a lane.
+func newLane(workers int, options subscriptionOptions) *lane {
+	l := &lane{workers: workers, capacity: options.bufferSize, policy: options.overflow}
+	l.space = sync.NewCond(&l.mu)
+	return l
+}
+
+// push runs a task on a new worker, or adds it to the pending tasks if all the workers are busy.
+// It returns true if a task (the new one or the oldest pending one) was dropped due to the overflow policy.
+func (l *lane) push(task func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.running >= l.workers && len(l.pending) >= l.capacity {
+		switch l.policy {
+		case OverflowDropNewest:
+			return true
+		case OverflowDropOldest:
+			if len(l.pending) > 0 {
+				l.pending[0] = nil
+				l.pending = append(l.pending[1:], task)
+			}
+			return true
+		default:
+			l.space.Wait()
+		}
+	}
+
+	// Workers exit only when there are no pending tasks, so pending tasks always run before the new one
+	if l.running < l.workers {
+		l.running++
+		go l.work(task)
+	} else {
+		l.pending = append(l.pending, task)
+	}
+	return false
+}
+
+// work runs a task and then the pending tasks, until there are none.
+func (l *lane) work(task func()) {
+	for task != nil {
+		task()
+
+		l.mu.Lock()
+		if len(l.pending) > 0 {
+			task = l.pending[0]
+			l.pending[0] = nil
+			l.pending = l.pending[1:]
+		} else {
+			task = nil
+			l.running--
+		}
+		l.space.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+// endregion
+
+// region In-flight callbacks ------------------------------------------------------------------------------------------
+
+// handlerGroup counts the in-flight callbacks, so they can be drained on shutdown.
+type handlerGroup struct {
+	mu    sync.Mutex
+	count int
+	idle  chan struct{} // closed when the count drops to zero
+}
+
+// add records the start of a callback.
+func (g *handlerGroup) add() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.count == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.count++
+}
+
+// done records the end of a callback.
+func (g *handlerGroup) done() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.count--; g.count == 0 {
+		close(g.idle)
+	}
+}
+
+// wait waits for the in-flight callbacks to finish, up to the timeout.
+// It returns the number of callbacks still running.
+func (g *handlerGroup) wait(timeout time.Duration) int {
+	g.mu.Lock()
+	if g.count == 0 {
+		g.mu.Unlock()
+		return 0
+	}
+	idle := g.idle
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		return 0
+	case <-time.After(timeout):
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.count
+	}
+}
+
+// endregion
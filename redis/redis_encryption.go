@@ -0,0 +1,299 @@
+// Client-side envelope encryption of entities and messages
+//
+
+package facilities
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// encryptedFrame identifies an encrypted value (the byte following the frameMarker).
+//
+// The layout of an encrypted value is:
+// frameMarker | encryptedFrame | key id length (1 byte) | key id | wrapped data key (60 bytes) | nonce (12 bytes) | ciphertext
+//
+// Every value is encrypted with its own random data key (AES-256-GCM), and the data key is encrypted ("wrapped")
+// with the master key identified by the key id, so master keys can be rotated without re-encrypting existing values.
+const encryptedFrame byte = 0x10
+
+const (
+	dataKeySize    = 32
+	nonceSize      = 12
+	wrappedKeySize = nonceSize + dataKeySize + 16 // nonce + encrypted data key + GCM tag
+)
+
+var (
+	// ErrKeyNotFound is returned by a KeyProvider when the requested master key does not exist.
+	ErrKeyNotFound = errors.New("encryption key not found")
+
+	// ErrNoKeyProvider is returned when reading an encrypted value without a configured KeyProvider.
+	ErrNoKeyProvider = errors.New("value is encrypted but no key provider is configured")
+
+	// ErrEncryptedComparison is returned by the list operations matching elements by value (LPos, LInsert, LRem)
+	// on encrypted keys: every encryption uses a random nonce, so an encrypted entity never equals a stored element.
+	ErrEncryptedComparison = errors.New("elements of encrypted keys cannot be matched by value, use the raw variant with the stored bytes")
+)
+
+// KeyProvider provides the master keys used to encrypt and decrypt values. Keys are AES keys of 16, 24 or 32 bytes.
+type KeyProvider interface {
+	// CurrentKey returns the id and the value of the master key used to encrypt new values
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the master key with the given id, used to decrypt values (ErrKeyNotFound if it does not exist)
+	Key(id string) ([]byte, error)
+}
+
+// WithKeyEncryption encrypts the entities stored in keys (including hashes and lists) starting with any of the
+// given prefixes, using master keys from the provider. Use an empty prefix to encrypt all the keys.
+func WithKeyEncryption(provider KeyProvider, prefixes ...string) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.keyEncryption.add(provider, prefixes...)
+	}
+}
+
+// WithTopicEncryption encrypts the messages published or pushed to topics (or queues) starting with any of the
+// given prefixes, using master keys from the provider. Use an empty prefix to encrypt all the topics.
+func WithTopicEncryption(provider KeyProvider, prefixes ...string) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.topicEncryption.add(provider, prefixes...)
+	}
+}
+
+// region Key ring -----------------------------------------------------------------------------------------------------
+
+// KeyRing is an in-memory KeyProvider holding the current master key and the older keys still needed for decryption.
+// It is safe for concurrent use.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing creates a KeyRing with the master key used to encrypt new values.
+func NewKeyRing(id string, key []byte) (*KeyRing, error) {
+	kr := &KeyRing{keys: make(map[string][]byte)}
+	if err := kr.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Add adds a master key used only to decrypt values written with it (e.g. a retired key).
+func (k *KeyRing) Add(id string, key []byte) error {
+	if err := validateMasterKey(id, key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds a master key and makes it the current key. Values written with the previous keys remain readable.
+func (k *KeyRing) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = id
+	return nil
+}
+
+// Remove removes a master key which is no longer in use. The current key cannot be removed.
+func (k *KeyRing) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("can't remove the current encryption key: %s", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// CurrentKey returns the id and the value of the current master key.
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the master key with the given id.
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// validateMasterKey checks the master key id and size.
+func validateMasterKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid encryption key id: length must be 1-255 bytes")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid encryption key size %d: must be 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// endregion
+
+// region Encryption ---------------------------------------------------------------------------------------------------
+
+// prefixProvider maps a key or topic prefix to a key provider.
+type prefixProvider struct {
+	prefix   string
+	provider KeyProvider
+}
+
+// encryptionRegistry selects the key provider of a key or a topic by the longest matching prefix.
+type encryptionRegistry struct {
+	prefixes []prefixProvider
+}
+
+// add registers a key provider for prefixes, keeping the longest prefixes first.
+func (e *encryptionRegistry) add(provider KeyProvider, prefixes ...string) {
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	for _, prefix := range prefixes {
+		e.prefixes = append(e.prefixes, prefixProvider{prefix: prefix, provider: provider})
+	}
+	sort.SliceStable(e.prefixes, func(i, j int) bool {
+		return len(e.prefixes[i].prefix) > len(e.prefixes[j].prefix)
+	})
+}
+
+// get returns the key provider of a key or a topic, or nil if it is not encrypted.
+func (e *encryptionRegistry) get(name string) KeyProvider {
+	for _, pp := range e.prefixes {
+		if strings.HasPrefix(name, pp.prefix) {
+			return pp.provider
+		}
+	}
+	return nil
+}
+
+// decryptionProvider returns the key provider used to decrypt a value of a key or a topic: its own provider,
+// or any configured provider for values written before the prefixes configuration was changed.
+func (e *encryptionRegistry) decryptionProvider(name string) KeyProvider {
+	if provider := e.get(name); provider != nil {
+		return provider
+	}
+	if len(e.prefixes) > 0 {
+		return e.prefixes[0].provider
+	}
+	return nil
+}
+
+// encrypt encrypts the data using a random data key wrapped with the current master key of the provider.
+func encrypt(provider KeyProvider, data []byte) ([]byte, error) {
+	id, masterKey, err := provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if err = validateMasterKey(id, masterKey); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := seal(masterKey, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 3+len(id)+wrappedKeySize)
+	header = append(header, frameMarker, encryptedFrame, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, wrappedKey...)
+
+	// The header is authenticated along with the data, so the key id and the wrapped key can't be tampered with
+	ciphertext, err := seal(dataKey, data, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ciphertext...), nil
+}
+
+// isEncrypted checks if the data has an encryption frame header.
+func isEncrypted(data []byte) bool {
+	return len(data) >= 3 && data[0] == frameMarker && data[1] == encryptedFrame
+}
+
+// decrypt decrypts data encrypted by encrypt, using the master key identified in the data.
+func decrypt(provider KeyProvider, data []byte) ([]byte, error) {
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	headerSize := 3 + int(data[2]) + wrappedKeySize
+	if len(data) < headerSize+nonceSize {
+		return nil, fmt.Errorf("invalid encrypted value: too short")
+	}
+	id := string(data[3 : 3+int(data[2])])
+	header := data[:headerSize]
+
+	masterKey, err := provider.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %s: %w", id, err)
+	}
+	dataKey, err := open(masterKey, data[3+len(id):headerSize], nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	plaintext, err := open(dataKey, data[headerSize:], header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// seal encrypts and authenticates data using AES-GCM, returning the random nonce followed by the ciphertext.
+func seal(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+gcm.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open decrypts and authenticates data encrypted by seal.
+func open(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+}
+
+// newGCM creates an AES-GCM cipher.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// endregion
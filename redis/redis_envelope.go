@@ -0,0 +1,230 @@
+// Message envelope with headers for the redis implementation of IMessageBus
+//
+// With WithEnvelopes, messages are sent wrapped in an envelope holding headers (metadata independent of the message
+// body, e.g. trace context, content type or producer identity). The layout of an envelope is:
+// frameMarker | envelopeFrame | headers length (uvarint) | headers (JSON) | encoded message
+//
+// The encoded message may itself be framed (compressed or encrypted). The headers are neither compressed nor
+// encrypted. Bare payloads (sent without an envelope, e.g. by older versions) are accepted as messages without headers.
+
+package facilities
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// envelopeFrame identifies an envelope (the byte following the frameMarker).
+const envelopeFrame byte = 0x20
+
+// maxHeadersSize bounds the size of the headers of an envelope
+const maxHeadersSize = 1 << 20
+
+// The headers populated automatically by the producers.
+const (
+	HeaderMessageId     = "message-id"     // A unique id of the sent message
+	HeaderTimestamp     = "timestamp"      // The time the message was sent (RFC 3339, UTC)
+	HeaderContentType   = "content-type"   // The name of the codec the message body is encoded with (e.g. "json")
+	HeaderSchemaVersion = "schema-version" // The version of the message (IMessage.Version), if any
+	HeaderProducer      = "producer"       // The identity of the producer (see WithProducerId)
+	HeaderCorrelationId = "correlation-id" // The correlation id of a request and its reply (see Request)
+)
+
+// The headers of the request/reply protocol (see Request).
+const (
+	headerDeadline = "deadline" // The time (Unix milliseconds) after which the requester no longer waits
+	headerError    = "error"    // The error of the responder (replies only)
+)
+
+// automaticHeaders are the headers set by the adapter, never copied from the headers of a sent message.
+var automaticHeaders = map[string]bool{
+	HeaderMessageId:     true,
+	HeaderTimestamp:     true,
+	HeaderContentType:   true,
+	HeaderSchemaVersion: true,
+	HeaderProducer:      true,
+	HeaderCorrelationId: true,
+	headerDeadline:      true,
+	headerError:         true,
+}
+
+// Headers are the metadata of a message, sent in its envelope.
+type Headers map[string]string
+
+// HeadersCarrier is implemented by messages exposing their envelope headers, usually by embedding Envelope.
+// The headers of a sent message are added to the envelope, except the automatic ones (e.g. HeaderMessageId) which
+// are always set by the adapter, so a received message can be sent again. The headers of a received message are
+// set on it.
+type HeadersCarrier interface {
+	Headers() Headers
+	SetHeaders(headers Headers)
+}
+
+// Envelope implements HeadersCarrier, to be embedded in messages:
+//
+//	type HeroMessage struct {
+//		BaseMessage
+//		facilities.Envelope
+//		Hero *Hero `json:"hero"`
+//	}
+//
+// The headers are not part of the message body.
+type Envelope struct {
+	EnvelopeHeaders Headers `json:"-" msgpack:"-" cbor:"-"`
+}
+
+// Headers returns the headers of the message.
+func (e *Envelope) Headers() Headers {
+	return e.EnvelopeHeaders
+}
+
+// SetHeaders sets the headers of the message.
+func (e *Envelope) SetHeaders(headers Headers) {
+	e.EnvelopeHeaders = headers
+}
+
+// WithProducerId sets the identity of the producer in the envelope of the sent messages
+// (the host name and the process id by default).
+func WithProducerId(id string) Option {
+	return func(r *RedisAdapter) {
+		r.serializer.producer = id
+	}
+}
+
+// WithEnvelopes sends the messages in an envelope holding their headers. Consumers using versions which do not
+// support envelopes fail to decode them, so upgrade all the consumers of a topic (or a queue) before enabling it on
+// its producers. Messages sent using Request, and their replies, always have an envelope. Envelopes and bare payloads
+// are always accepted.
+func WithEnvelopes() Option {
+	return func(r *RedisAdapter) {
+		r.serializer.envelopes = true
+	}
+}
+
+// defaultProducerId returns the default identity of the producer: the host name and the process id.
+func defaultProducerId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// region Serialization ------------------------------------------------------------------------------------------------
+
+// messageToEnvelope converts a message to an envelope sent to a topic (or a queue), with additional headers.
+func (s *serializer) messageToEnvelope(topic string, message IMessage, headers Headers) ([]byte, error) {
+	body, err := s.topics.get(topic).Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = s.encode(s.topicEncryption.get(topic), body); err != nil {
+		return nil, err
+	}
+	if !s.envelopes && len(headers) == 0 {
+		return body, nil
+	}
+
+	envelope := Headers{
+		HeaderMessageId:   NanoID(),
+		HeaderTimestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		HeaderContentType: s.topics.get(topic).Name(),
+		HeaderProducer:    s.producer,
+	}
+	if version := message.Version(); version != "" {
+		envelope[HeaderSchemaVersion] = version
+	}
+	if carrier, ok := message.(HeadersCarrier); ok {
+		for name, value := range carrier.Headers() {
+			if !automaticHeaders[name] {
+				envelope[name] = value
+			}
+		}
+	}
+	for name, value := range headers {
+		envelope[name] = value
+	}
+	return encodeEnvelope(envelope, body)
+}
+
+// rawToEnvelope converts raw data received from a topic (or a queue) to a message and its headers
+// (nil for a bare payload). The headers are set on messages implementing HeadersCarrier.
+func (s *serializer) rawToEnvelope(topic string, factory MessageFactory, data []byte) (IMessage, Headers, error) {
+	headers, body, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	message, err := s.bodyToMessage(topic, factory, body)
+	if err != nil {
+		return nil, headers, err
+	}
+	if carrier, ok := message.(HeadersCarrier); ok && headers != nil {
+		carrier.SetHeaders(headers)
+	}
+	return message, headers, nil
+}
+
+// bodyToMessage converts the encoded message of an envelope (or a bare payload) to a message.
+func (s *serializer) bodyToMessage(topic string, factory MessageFactory, body []byte) (IMessage, error) {
+	message := factory()
+	if data, err := s.decode(s.topicEncryption.decryptionProvider(topic), body); err != nil {
+		return nil, err
+	} else if err = s.topics.get(topic).Unmarshal(data, message); err != nil {
+		return nil, err
+	} else {
+		return message, nil
+	}
+}
+
+// encodeEnvelope wraps an encoded message in an envelope.
+func encodeEnvelope(headers Headers, body []byte) ([]byte, error) {
+	headerBytes, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, 2+binary.MaxVarintLen32+len(headerBytes)+len(body))
+	data = append(data, frameMarker, envelopeFrame)
+	data = binary.AppendUvarint(data, uint64(len(headerBytes)))
+	data = append(data, headerBytes...)
+	return append(data, body...), nil
+}
+
+// isEnvelope checks if the data has an envelope frame header.
+func isEnvelope(data []byte) bool {
+	return len(data) >= 2 && data[0] == frameMarker && data[1] == envelopeFrame
+}
+
+// decodeEnvelope splits an envelope into its headers and the encoded message.
+// A bare payload is returned as-is, with nil headers.
+func decodeEnvelope(data []byte) (Headers, []byte, error) {
+	if !isEnvelope(data) {
+		return nil, data, nil
+	}
+	size, n := binary.Uvarint(data[2:])
+	if n <= 0 || size > maxHeadersSize || uint64(len(data)-2-n) < size {
+		return nil, nil, fmt.Errorf("invalid message envelope")
+	}
+	start := 2 + n
+	headers := make(Headers)
+	if err := json.Unmarshal(data[start:start+int(size)], &headers); err != nil {
+		return nil, nil, fmt.Errorf("invalid message envelope: %w", err)
+	}
+	return headers, data[start+int(size):], nil
+}
+
+// deadline returns the time of a Unix milliseconds header, or the zero time if it is missing or invalid.
+func (h Headers) deadline() time.Time {
+	if ms, err := strconv.ParseInt(h[headerDeadline], 10, 64); err == nil {
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
+
+// endregion
@@ -0,0 +1,135 @@
+// Topic and subscription introspection for the redis implementation of IMessageBus
+//
+
+package facilities
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// SubscriptionInfo describes an active subscription of the adapter.
+type SubscriptionInfo struct {
+	Id        string             // The subscription ID
+	Name      string             // The subscriber name
+	Topics    []string           // The topics (or patterns, or queues), without the namespace
+	IsPattern bool               // True if the topics are patterns
+	Health    SubscriptionHealth // The health and counters of the subscription
+}
+
+// Subscriptions returns the active subscriptions of the adapter (including key event subscriptions and responders),
+// sorted by subscriber name and ID.
+func (r *RedisAdapter) Subscriptions() []SubscriptionInfo {
+	r.RLock()
+	defer r.RUnlock()
+
+	result := make([]SubscriptionInfo, 0, len(r.subs))
+	for id, s := range r.subs {
+		topics := make([]string, 0, len(s.topics))
+		for _, topic := range s.topics {
+			topics = append(topics, r.ns.strip(topic))
+		}
+		result = append(result, SubscriptionInfo{Id: id, Name: s.name, Topics: topics, IsPattern: s.isPattern, Health: s.health()})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+// ActiveTopics returns the sorted topics matching a pattern (use "" to match all the topics) which have at least one
+// subscriber, in any process (using PUBSUB CHANNELS). Topics subscribed only by pattern subscriptions are not included.
+// On a Redis Cluster, the topics of all the nodes are returned.
+func (r *RedisAdapter) ActiveTopics(pattern string) ([]string, error) {
+	found := make(map[string]bool)
+	err := r.forEachPubSubNode(func(node redis.Cmdable) error {
+		channels, err := node.PubSubChannels(r.ctx, r.ns.pattern(pattern)).Result()
+		for _, channel := range channels {
+			found[r.ns.strip(channel)] = true
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]string, 0, len(found))
+	for topic := range found {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// TopicSubscribers returns the number of subscribers of every topic, in any process (using PUBSUB NUMSUB).
+// Pattern subscriptions are not counted. On a Redis Cluster, the subscribers of all the nodes are counted.
+func (r *RedisAdapter) TopicSubscribers(topics ...string) (map[string]int64, error) {
+	result := make(map[string]int64, len(topics))
+	for _, topic := range topics {
+		result[topic] = 0
+	}
+	if len(topics) == 0 {
+		return result, nil
+	}
+
+	err := r.forEachPubSubNode(func(node redis.Cmdable) error {
+		counts, err := node.PubSubNumSub(r.ctx, r.ns.keys(topics)...).Result()
+		for channel, count := range counts {
+			result[r.ns.strip(channel)] += count
+		}
+		return err
+	})
+	return result, err
+}
+
+// PatternSubscriptions returns the number of pattern subscriptions, in any process and any namespace
+// (using PUBSUB NUMPAT). On a Redis Cluster, the pattern subscriptions of all the nodes are counted.
+func (r *RedisAdapter) PatternSubscriptions() (int64, error) {
+	var total int64
+	err := r.forEachPubSubNode(func(node redis.Cmdable) error {
+		count, err := node.PubSubNumPat(r.ctx).Result()
+		total += count
+		return err
+	})
+	return total, err
+}
+
+// PublishCount publishes a message like Publish, and returns the number of subscribers which received it
+// (0 means the message was published to nobody). On a Redis Cluster, only the subscribers connected to the node
+// the message was published to are counted.
+func (r *RedisAdapter) PublishCount(message IMessage) (int64, error) {
+	var receivers int64
+	err := chainPublish(func(msg IMessage) error {
+		if bytes, err := r.serializer.messageToRaw(msg.Topic(), msg); err != nil {
+			return err
+		} else {
+			receivers, err = r.rc.Publish(r.ctx, r.ns.key(msg.Topic()), bytes).Result()
+			return err
+		}
+	}, r.pubMiddlewares)(message)
+	return receivers, err
+}
+
+// forEachPubSubNode runs a function on every node holding subscriptions (every node of a Redis Cluster),
+// one node at a time.
+func (r *RedisAdapter) forEachPubSubNode(fn func(node redis.Cmdable) error) error {
+	cluster, ok := r.rc.(*redis.ClusterClient)
+	if !ok {
+		return fn(r.rc)
+	}
+
+	var mu sync.Mutex
+	return cluster.ForEachShard(r.ctx, func(ctx context.Context, client *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn(client)
+	})
+}
@@ -0,0 +1,187 @@
+// Keyspace notification subscriptions for the redis implementation of IDataCache
+//
+
+package facilities
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/logger"
+)
+
+// notifyKeyspaceEvents is the redis configuration parameter enabling keyspace notifications
+const notifyKeyspaceEvents = "notify-keyspace-events"
+
+// KeyEventType is the type of keyspace notification (the name of the redis event)
+type KeyEventType string
+
+const (
+	KeyExpired KeyEventType = "expired" // The key expired
+	KeyEvicted KeyEventType = "evicted" // The key was evicted due to the maxmemory policy
+	KeySet     KeyEventType = "set"     // The value of the key was set
+	KeyDel     KeyEventType = "del"     // The key was deleted
+	KeyHSet    KeyEventType = "hset"    // A field of the hash was set
+)
+
+// keyEventClasses maps the event types to the notify-keyspace-events class flag generating them
+var keyEventClasses = map[KeyEventType]string{
+	KeyExpired: "x",
+	KeyEvicted: "e",
+	KeySet:     "$",
+	KeyDel:     "g",
+	KeyHSet:    "h",
+}
+
+// KeyEvent is a keyspace notification.
+type KeyEvent struct {
+	Key   string       // The key (without the namespace, see WithNamespace)
+	Event KeyEventType // The event type
+}
+
+// KeyEventCallback is invoked for each received keyspace notification.
+type KeyEventCallback func(event KeyEvent)
+
+// KeyEventOption configures a keyspace notification subscription (see SubscribeKeyEvents).
+type KeyEventOption func(*keyEventOptions)
+
+// keyEventOptions holds the SubscribeKeyEvents configuration.
+type keyEventOptions struct {
+	events     []KeyEventType // the event types to deliver (none means all the events)
+	autoEnable bool           // enable the required notify-keyspace-events classes
+}
+
+// WithKeyEventTypes delivers only the given event types (by default all the events of the keys are delivered).
+func WithKeyEventTypes(events ...KeyEventType) KeyEventOption {
+	return func(o *keyEventOptions) {
+		o.events = append(o.events, events...)
+	}
+}
+
+// WithKeyEventsAutoEnable enables the keyspace notifications required by the subscription in the redis
+// configuration (notify-keyspace-events), in addition to the already enabled ones. It requires the CONFIG
+// command to be allowed; managed redis services usually require enabling notifications in their console instead.
+func WithKeyEventsAutoEnable() KeyEventOption {
+	return func(o *keyEventOptions) {
+		o.autoEnable = true
+	}
+}
+
+// SubscribeKeyEvents subscribes to the keyspace notifications of the keys matching a pattern (e.g. "session:*")
+// and invokes the callback for each event. Callbacks are dispatched on bounded goroutines like the
+// message subscriptions. It returns a subscription ID which can be removed using Unsubscribe.
+//
+// Keyspace notifications are disabled by default in redis: enable them in the server configuration
+// or use WithKeyEventsAutoEnable. Notifications are not reliable: events fired while the subscriber
+// is disconnected are lost. On a Redis Cluster, notifications are local to every node, so only the events
+// of the keys held by the node the subscription is connected to are received.
+func (r *RedisAdapter) SubscribeKeyEvents(pattern string, callback KeyEventCallback, options ...KeyEventOption) (string, error) {
+
+	opts := keyEventOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	for _, event := range opts.events {
+		if _, ok := keyEventClasses[event]; !ok {
+			return "", fmt.Errorf("unsupported key event: %s", event)
+		}
+	}
+
+	if opts.autoEnable {
+		if err := r.enableKeyEvents(opts.events); err != nil {
+			return "", err
+		}
+	}
+
+	prefix := fmt.Sprintf("__keyspace@%d__:", r.db())
+	channel := prefix + r.ns.pattern(pattern)
+	ps, err := r.openPubSub([]string{channel}, true)
+	if err != nil {
+		return "", err
+	}
+
+	filter := make(map[KeyEventType]bool)
+	for _, event := range opts.events {
+		filter[event] = true
+	}
+
+	subscriptionId := NanoID()
+
+	r.Lock()
+	defer r.Unlock()
+	s := r.newSubscriber(subscriptionId, "", ps, []string{channel}, true, newSubscriptionOptions())
+	r.subs[subscriptionId] = s
+	go r.keyEventSubscriber(s, prefix, filter, callback)
+	return subscriptionId, nil
+}
+
+// keyEventSubscriber is a function running an infinite loop to get keyspace notifications.
+func (r *RedisAdapter) keyEventSubscriber(s *subscriber, prefix string, filter map[KeyEventType]bool, callback KeyEventCallback) {
+
+	r.receive(s, func(m *redis.Message) {
+		event := KeyEvent{
+			Key:   r.ns.strip(strings.TrimPrefix(m.Channel, prefix)),
+			Event: KeyEventType(m.Payload),
+		}
+		if len(filter) > 0 && !filter[event.Event] {
+			return
+		}
+		s.handlers.dispatch(event.Key, func() {
+			r.invoke(s, m.Channel, []byte(m.Payload), func() { callback(event) })
+		})
+	})
+}
+
+// enableKeyEvents adds the keyspace notification classes required for the events to the redis configuration
+// of every node.
+func (r *RedisAdapter) enableKeyEvents(events []KeyEventType) error {
+	if len(events) == 0 {
+		events = []KeyEventType{KeyExpired, KeyEvicted, KeySet, KeyDel, KeyHSet}
+	}
+	required := "K"
+	for _, event := range events {
+		required += keyEventClasses[event]
+	}
+
+	nodes, err := r.scanNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		current, er := node.ConfigGet(r.ctx, notifyKeyspaceEvents).Result()
+		if er != nil {
+			return fmt.Errorf("failed to read %s: %w", notifyKeyspaceEvents, er)
+		}
+		if flags, changed := mergeKeyEventFlags(current[notifyKeyspaceEvents], required); changed {
+			if er = node.ConfigSet(r.ctx, notifyKeyspaceEvents, flags).Err(); er != nil {
+				return fmt.Errorf("failed to set %s: %w", notifyKeyspaceEvents, er)
+			}
+			logger.Info("SubscribeKeyEvents: %s changed to %s", notifyKeyspaceEvents, flags)
+		}
+	}
+	return nil
+}
+
+// mergeKeyEventFlags adds the required flags to the current notify-keyspace-events flags.
+// The "A" flag (alias for all the classes) already includes all the required classes but the keyspace flags.
+func mergeKeyEventFlags(current, required string) (string, bool) {
+	flags := current
+	for _, flag := range required {
+		if strings.ContainsRune(flags, flag) || (flag != 'K' && strings.ContainsRune(flags, 'A')) {
+			continue
+		}
+		flags += string(flag)
+	}
+	return flags, flags != current
+}
+
+// db returns the database number of the redis connection (always 0 on a Redis Cluster).
+func (r *RedisAdapter) db() int {
+	if client, ok := r.rc.(*redis.Client); ok {
+		return client.Options().DB
+	}
+	return 0
+}
@@ -0,0 +1,269 @@
+// Two-level caching: an in-process LRU (L1) in front of redis for the redis implementation of IDataCache
+//
+
+package facilities
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/logger"
+)
+
+// localCacheChannel is the pub/sub channel used to invalidate the local caches of all the adapters
+const localCacheChannel = "yaaf:local-cache:invalidate"
+
+// WithLocalCache adds an in-process LRU cache (L1) in front of redis for the keys read by Get and GetRaw.
+// The cache holds up to maxEntries keys, each for up to ttl. Writes and deletes made through any adapter with a
+// local cache (in any process) invalidate the key in all the local caches using a pub/sub channel. Changes made
+// by other clients (or by expiration in redis) are not notified, so the ttl bounds the staleness of the cached values.
+func WithLocalCache(maxEntries int, ttl time.Duration) Option {
+	return func(r *RedisAdapter) {
+		r.local = newLocalCache(maxEntries, ttl)
+	}
+}
+
+// LocalCacheStats holds the statistics of the local cache (see WithLocalCache).
+type LocalCacheStats struct {
+	Hits          uint64 // Number of reads served from the local cache
+	Misses        uint64 // Number of reads served from redis
+	Evictions     uint64 // Number of entries evicted to make room for new entries
+	Invalidations uint64 // Number of entries removed due to writes, deletes or expiration
+	Entries       int    // Current number of entries
+}
+
+// HitRatio returns the ratio of reads served from the local cache (0 if there were no reads).
+func (s LocalCacheStats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// LocalCacheStats returns the statistics of the local cache (empty if no local cache is configured).
+func (r *RedisAdapter) LocalCacheStats() LocalCacheStats {
+	if r.local == nil {
+		return LocalCacheStats{}
+	}
+	return r.local.stats()
+}
+
+// region Adapter integration ------------------------------------------------------------------------------------------
+
+// invalidation is the message published to the local cache channel when keys are changed.
+type invalidation struct {
+	Origin string   `json:"origin"`         // The id of the publishing adapter (which already invalidated its own cache)
+	Keys   []string `json:"keys,omitempty"` // The changed keys, none means all the keys
+}
+
+// startLocalCache subscribes to the local cache invalidation channel.
+func (r *RedisAdapter) startLocalCache() {
+	r.local.ps = r.rc.Subscribe(r.ctx, r.ns.key(localCacheChannel))
+	go r.localCacheInvalidator(r.local.ps)
+}
+
+// localCacheInvalidator is a function running an infinite loop to get invalidation messages from other adapters.
+// Messages published while the connection is down are lost, so the entire cache is cleared on every (re)subscription.
+func (r *RedisAdapter) localCacheInvalidator(ps *redis.PubSub) {
+	for m := range ps.ChannelWithSubscriptions() {
+		switch msg := m.(type) {
+		case *redis.Subscription:
+			r.local.clear()
+		case *redis.Message:
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				logger.Warn("LocalCache: invalid invalidation message: %s", err.Error())
+				r.local.clear()
+			} else if inv.Origin == r.local.origin {
+				continue
+			} else if len(inv.Keys) == 0 {
+				r.local.clear()
+			} else {
+				r.local.remove(inv.Keys...)
+			}
+		}
+	}
+}
+
+// invalidateLocal removes keys from the local cache of this adapter and of all the other adapters.
+// Calling it with no keys clears the local caches entirely.
+func (r *RedisAdapter) invalidateLocal(keys ...string) {
+	if r.local == nil {
+		return
+	}
+	if len(keys) == 0 {
+		r.local.clear()
+	} else {
+		r.local.remove(keys...)
+	}
+	if bytes, err := json.Marshal(invalidation{Origin: r.local.origin, Keys: keys}); err != nil {
+		logger.Warn("LocalCache: failed to marshal invalidation message: %s", err.Error())
+	} else if err = r.rc.Publish(r.ctx, r.ns.key(localCacheChannel), bytes).Err(); err != nil {
+		logger.Warn("LocalCache: failed to publish invalidation message: %s", err.Error())
+	}
+}
+
+// endregion
+
+// region LRU cache ----------------------------------------------------------------------------------------------------
+
+// localCache is a size- and TTL-bounded LRU cache of raw values, safe for concurrent use.
+// Raw values are cached (rather than entities) so every reader decodes its own copy of the entity.
+type localCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	items      map[string]*list.Element
+	lru        *list.List // most recently used entries first
+	generation uint64     // incremented on every invalidation (see fetch)
+	origin     string
+	ps         *redis.PubSub
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// localEntry is an entry of the local cache.
+type localEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// newLocalCache creates an empty local cache.
+func newLocalCache(maxEntries int, ttl time.Duration) *localCache {
+	return &localCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		origin:     NanoID(),
+	}
+}
+
+// fetch gets the value of a key from the cache, or from the loader on a cache miss.
+// A loaded value is cached only if no invalidation occurred while it was loaded, so a value read
+// before a concurrent write is never cached after the write invalidated the key.
+func (c *localCache) fetch(key string, loader func(key string) ([]byte, error)) ([]byte, error) {
+	if value, ok := c.get(key); ok {
+		c.hits.Add(1)
+		return value, nil
+	}
+	c.misses.Add(1)
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	value, err := loader(key)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, value, generation)
+	return value, nil
+}
+
+// get returns a copy of the cached value of a key, if it exists and has not expired.
+func (c *localCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		c.invalidations.Add(1)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return bytes.Clone(entry.value), true
+}
+
+// put caches the value of a key, unless the cache was invalidated since the given generation.
+func (c *localCache) put(key string, value []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.maxEntries <= 0 {
+		return
+	}
+	entry := &localEntry{key: key, value: bytes.Clone(value), expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove removes keys from the cache.
+func (c *localCache) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.removeElement(elem)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// clear removes all the keys from the cache.
+func (c *localCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.invalidations.Add(uint64(c.lru.Len()))
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// removeElement removes an entry from the cache, the caller must hold the lock.
+func (c *localCache) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.items, elem.Value.(*localEntry).key)
+}
+
+// stats returns the cache statistics.
+func (c *localCache) stats() LocalCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return LocalCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+	}
+}
+
+// close stops listening to invalidation messages.
+func (c *localCache) close() error {
+	if c.ps == nil {
+		return nil
+	}
+	return c.ps.Close()
+}
+
+// endregion
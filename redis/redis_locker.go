@@ -28,7 +28,7 @@ var (
 
 // Locker represents an obtained, distributed lock.
 type Locker struct {
-	rc    *redis.Client
+	rc    redis.UniversalClient
 	key   string
 	token string
 }
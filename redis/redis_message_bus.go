@@ -5,8 +5,11 @@ package facilities
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,31 +19,55 @@ import (
 	. "github.com/go-yaaf/yaaf-common/messaging"
 )
 
-// maxConcurrentHandlers bounds the number of message-callback goroutines a single
-// subscription may run concurrently. Without a bound, a high-volume or malicious
-// publisher could spawn unbounded goroutines and exhaust memory (resource-exhaustion DoS).
-const maxConcurrentHandlers = 256
+// popPollInterval is the interval at which Pop and PopBatch poll the queues during the last (sub-second) part of
+// their timeout, which BRPOP cannot wait for
+const popPollInterval = 50 * time.Millisecond
 
 // region Message Bus actions ------------------------------------------------------------------------------------------
 
-// Publish publishes messages to a channel (topic).
+// Publish publishes messages to their channels (topics). Several messages are sent in a single round-trip
+// (see PublishBatch): messages are sent independently, and it returns the errors of the failed messages.
+// The publish middlewares wrap the actual send of every message, whatever the number of messages.
 func (r *RedisAdapter) Publish(messages ...IMessage) error {
-	for _, message := range messages {
-		if bytes, err := messageToRaw(message); err != nil {
-			return err
-		} else {
-			if res := r.rc.Publish(r.ctx, message.Topic(), bytes); res.Err() != nil {
-				return res.Err()
-			}
-		}
+	if len(messages) == 1 {
+		return chainPublish(r.publish, r.pubMiddlewares)(messages[0])
+	}
+	_, err := r.PublishBatch(BatchBestEffort, messages...)
+	return err
+}
+
+// publish publishes a message to its channel (topic).
+func (r *RedisAdapter) publish(message IMessage) error {
+	if bytes, err := r.serializer.messageToRaw(message.Topic(), message); err != nil {
+		return err
+	} else {
+		return r.rc.Publish(r.ctx, r.ns.key(message.Topic()), bytes).Err()
 	}
-	return nil
 }
 
 // Subscribe subscribes to topics and invokes the callback function for each received message.
 // It supports pattern-based subscriptions (e.g., "my-topic-*").
 // It returns a subscription ID or an error.
 func (r *RedisAdapter) Subscribe(subscriberName string, factory MessageFactory, callback SubscriptionCallback, topics ...string) (string, error) {
+	return r.SubscribeWithOptions(subscriberName, factory, callback, topics)
+}
+
+// SubscribeWithOptions subscribes to topics like Subscribe, with options (e.g. WithResubscribe, WithConcurrency).
+// It returns an error if the subscription is not confirmed by redis. Once established, a subscription whose
+// connection is lost is re-established automatically; use SubscriptionHealth to check its state.
+func (r *RedisAdapter) SubscribeWithOptions(subscriberName string, factory MessageFactory, callback SubscriptionCallback, topics []string, options ...SubscriptionOption) (string, error) {
+	return r.subscribe(subscriberName, topics, newSubscriptionOptions(options...), func(s *subscriber) {
+		middlewares := [][]SubscriptionMiddleware{r.dedupMiddlewares(subscriberName), r.subMiddlewares, s.options.middlewares}
+		r.subscriber(s, chainSubscription(callback, middlewares...), factory)
+	})
+}
+
+// subscribe subscribes to topics (or patterns), registers the subscription and runs its receive loop.
+func (r *RedisAdapter) subscribe(subscriberName string, topics []string, options subscriptionOptions, run func(s *subscriber)) (string, error) {
+
+	if err := options.validate(); err != nil {
+		return "", err
+	}
 
 	topicArray := make([]string, 0)
 
@@ -50,53 +77,41 @@ func (r *RedisAdapter) Subscribe(subscriberName string, factory MessageFactory,
 		if strings.Contains(t, "*") {
 			isPattern = true
 		}
-		topicArray = append(topicArray, t)
+		topicArray = append(topicArray, r.ns.key(t))
 	}
 
-	var ps *redis.PubSub
-
-	if isPattern {
-		ps = r.rc.PSubscribe(r.ctx, topics...)
-	} else {
-		ps = r.rc.Subscribe(r.ctx, topics...)
+	ps, err := r.openPubSub(topicArray, isPattern)
+	if err != nil {
+		return "", err
 	}
 
 	subscriptionId := NanoID()
+	s := r.newSubscriber(subscriptionId, subscriberName, ps, topicArray, isPattern, options)
 
 	r.Lock()
 	defer r.Unlock()
-	r.subs[subscriptionId] = subscriber{ps: ps, topics: topicArray}
-	go r.subscriber(ps, callback, factory)
+	r.subs[subscriptionId] = s
+	go run(s)
 	return subscriptionId, nil
 }
 
-// subscriber is a function running an infinite loop to get messages from a channel.
-// Callbacks are dispatched on bounded goroutines (see maxConcurrentHandlers) so that a
-// burst of incoming messages cannot spawn an unbounded number of goroutines.
-func (r *RedisAdapter) subscriber(ps *redis.PubSub, callback SubscriptionCallback, factory MessageFactory) {
-
-	// Semaphore capping the number of in-flight callback goroutines.
-	sem := make(chan struct{}, maxConcurrentHandlers)
-
-LOOP:
-	for {
-		select {
-		case m := <-ps.Channel():
-			if m == nil {
-				break LOOP
-			}
-			message := factory()
-			if err := Unmarshal([]byte(m.Payload), &message); err != nil {
-				logger.Warn("Subscribe: failed to unmarshal message on topic %s: %s", m.Channel, err.Error())
-				continue
-			}
-			sem <- struct{}{} // blocks (applying back-pressure) once the bound is reached
-			go func() {
-				defer func() { <-sem }()
-				callback(message)
-			}()
+// subscriber is a function running an infinite loop to get messages from a channel (see receive).
+// Callbacks are dispatched on bounded goroutines (see WithConcurrency) so that a
+// burst of incoming messages cannot spawn an unbounded number of goroutines, and a panicking
+// callback is recovered and reported (see WithErrorHandler).
+func (r *RedisAdapter) subscriber(s *subscriber, callback SubscriptionCallback, factory MessageFactory) {
+
+	r.receive(s, func(m *redis.Message) {
+		topic, payload := r.ns.strip(m.Channel), []byte(m.Payload)
+		message, err := r.serializer.rawToMessage(topic, factory, payload)
+		if err != nil {
+			r.messageError(s, topic, payload, fmt.Errorf("failed to unmarshal message: %w", err))
+			return
 		}
-	}
+		s.handlers.dispatch(s.options.messageKey(message), func() {
+			r.invoke(s, topic, payload, func() { callback(message) })
+		})
+	})
 }
 
 // Unsubscribe removes a subscription by its ID.
@@ -108,73 +123,211 @@ func (r *RedisAdapter) Unsubscribe(subscriptionId string) bool {
 	if v, ok := r.subs[subscriptionId]; !ok {
 		return false
 	} else {
-		if err := v.ps.Unsubscribe(r.ctx, v.topics...); err != nil {
-			logger.Warn("Unsubscribe error unsubscribe: %s\n", err.Error())
-		}
-		if err := v.ps.Close(); err != nil {
-			logger.Warn("Unsubscribe error closing PubSub: %s\n", err.Error())
-		}
+		v.close()
 		delete(r.subs, subscriptionId)
 		return true
 	}
 }
 
-// Push appends one or multiple messages to a queue (using LPush).
+// Push appends one or multiple messages to their queues (using LPush). Several messages are sent in a single
+// round-trip (see PushBatch): messages are sent independently, and it returns the errors of the failed messages.
+// The publish middlewares wrap the actual send of every message, whatever the number of messages.
 func (r *RedisAdapter) Push(messages ...IMessage) error {
-	for _, message := range messages {
-		if bytes, err := messageToRaw(message); err != nil {
-			return err
-		} else {
-			if er := r.rc.LPush(r.ctx, message.Topic(), bytes).Err(); er != nil {
-				return er
-			}
-		}
+	if len(messages) == 1 {
+		return chainPublish(r.push, r.pubMiddlewares)(messages[0])
+	}
+	_, err := r.PushBatch(BatchBestEffort, messages...)
+	return err
+}
+
+// push appends a message to its queue.
+func (r *RedisAdapter) push(message IMessage) error {
+	if bytes, err := r.serializer.messageToRaw(message.Topic(), message); err != nil {
+		return err
+	} else {
+		return r.rc.LPush(r.ctx, r.ns.key(message.Topic()), bytes).Err()
 	}
-	return nil
 }
 
 // Pop removes and gets the last message from a queue (using RPop).
 // If a timeout is provided, it will block until a message is available or the timeout is reached (using BRPop).
+// Messages already processed are skipped (see WithDeduplication). On a Redis Cluster, all the queues must belong
+// to the same hash slot (use a hash tag, e.g. "{orders}:high" and "{orders}:low").
 func (r *RedisAdapter) Pop(factory MessageFactory, timeout time.Duration, queue ...string) (IMessage, error) {
 
+	deadline := time.Now().Add(timeout)
+	for {
+		var message IMessage
+		var popped string
+		var err error
+		if timeout > 0 {
+			// Keep waiting for the remaining time after a duplicate, never past the deadline
+			message, popped, err = r.popUntil(factory, deadline, queue...)
+		} else {
+			message, popped, err = r.pop(factory, queue...)
+		}
+		if err != nil || !r.isDuplicate(popped, message) {
+			return message, err
+		}
+	}
+}
+
+// popUntil waits for a message in one of the queues until the deadline (see brpopUntil), and returns the queue it was
+// removed from, or redis.Nil if none arrived.
+func (r *RedisAdapter) popUntil(factory MessageFactory, deadline time.Time, queue ...string) (IMessage, string, error) {
+	if len(queue) == 0 {
+		queue = append(queue, factory().Topic())
+	}
+	if result, err := r.brpopUntil(deadline, r.ns.keys(queue)); err != nil {
+		return nil, "", err
+	} else {
+		popped := r.ns.strip(result[0])
+		message, er := r.serializer.rawToMessage(popped, factory, []byte(result[1]))
+		return message, popped, er
+	}
+}
+
+// brpopUntil waits for a message in one of the queues (in redis) until the deadline, and returns the queue and the
+// raw message like BRPOP, or redis.Nil if none arrived. BRPOP waits for whole seconds only, so the last (sub-second)
+// part of the wait is done by polling the queues.
+func (r *RedisAdapter) brpopUntil(deadline time.Time, keys []string) ([]string, error) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, redis.Nil
+		}
+		if wait := remaining.Truncate(time.Second); wait > 0 {
+			if result, err := r.rc.BRPop(r.ctx, wait, keys...).Result(); !errors.Is(err, redis.Nil) {
+				return result, err
+			}
+			continue
+		}
+		for _, key := range keys {
+			if value, err := r.rc.RPop(r.ctx, key).Result(); err == nil {
+				return []string{key, value}, nil
+			} else if !errors.Is(err, redis.Nil) {
+				return nil, err
+			}
+		}
+		time.Sleep(min(popPollInterval, remaining))
+	}
+}
+
+// pop removes and gets the last message from the first queue (without waiting), and returns the queue it was
+// removed from.
+func (r *RedisAdapter) pop(factory MessageFactory, queue ...string) (IMessage, string, error) {
+
 	message := factory()
 
 	if len(queue) == 0 {
 		queue = append(queue, message.Topic())
 	}
 
-	if timeout == 0 {
-		if cmd := r.rc.RPop(r.ctx, queue[0]); cmd.Err() != nil {
-			return nil, cmd.Err()
+	if cmd := r.rc.RPop(r.ctx, r.ns.key(queue[0])); cmd.Err() != nil {
+		return nil, "", cmd.Err()
+	} else {
+		if bytes, er := cmd.Bytes(); er != nil {
+			return nil, "", er
 		} else {
-			if bytes, er := cmd.Bytes(); er != nil {
-				return nil, er
-			} else {
-				return rawToMessage(factory, bytes)
-			}
+			message, er = r.serializer.rawToMessage(queue[0], factory, bytes)
+			return message, queue[0], er
 		}
-	} else {
-		if cmd := r.rc.BRPop(r.ctx, timeout, queue...); cmd.Err() != nil {
-			return nil, cmd.Err()
+	}
+}
+
+// PopBatch removes and gets up to count messages from a queue (using RPop with a count, which requires redis 6.2).
+// Without a timeout, it gets the messages of the first non-empty queue (in the order of the queues). If a timeout is
+// provided, it blocks until a message is available in one of the queues or the timeout is reached (using BRPop), and
+// then gets up to count messages from that queue. Messages already processed are skipped, and if all of them were
+// skipped it keeps waiting for the remaining time, like Pop (see WithDeduplication). Messages that fail to decode are removed from the
+// queue and reported by a DecodeError (by index), along with the messages that were decoded successfully. If reading
+// the rest of the batch fails, the messages already removed are returned along with the error. On a Redis Cluster,
+// all the queues must belong to the same hash slot (use a hash tag).
+func (r *RedisAdapter) PopBatch(factory MessageFactory, count int, timeout time.Duration, queue ...string) ([]IMessage, error) {
+
+	if count <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d", count)
+	}
+	if len(queue) == 0 {
+		queue = append(queue, factory().Topic())
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if result, duplicates, err := r.popBatch(factory, count, timeout > 0, deadline, queue); !duplicates {
+			return result, err
+		}
+	}
+}
+
+// popBatch removes and gets up to count messages from the queues (see PopBatch), waiting until the deadline if wait is
+// set. It reports if all the messages removed were skipped as duplicates.
+func (r *RedisAdapter) popBatch(factory MessageFactory, count int, wait bool, deadline time.Time, queue []string) ([]IMessage, bool, error) {
+
+	var popErr error
+	raw := make([]string, 0, count)
+	popped, candidates := queue[0], queue
+	if wait {
+		if result, err := r.brpopUntil(deadline, r.ns.keys(queue)); err != nil {
+			return nil, false, err
 		} else {
-			if result, err := cmd.Result(); err != nil {
-				return nil, err
-			} else {
-				return rawToMessage(factory, []byte(result[1]))
-			}
+			popped, candidates = r.ns.strip(result[0]), []string{r.ns.strip(result[0])}
+			raw = append(raw, result[1])
 		}
 	}
+	for _, q := range candidates {
+		if len(raw) == count {
+			break
+		}
+		if result, err := r.rc.RPopCount(r.ctx, r.ns.key(q), count-len(raw)).Result(); err == nil {
+			popped = q
+			raw = append(raw, result...)
+			break
+		} else if !errors.Is(err, redis.Nil) {
+			// The message removed by BRPop (if any) is still returned
+			popErr = err
+			break
+		}
+	}
+	if len(raw) == 0 {
+		if popErr != nil {
+			return nil, false, popErr
+		}
+		return nil, false, redis.Nil
+	}
+
+	result := make([]IMessage, 0, len(raw))
+	failed := make(map[string]error)
+	for i, str := range raw {
+		if message, err := r.serializer.rawToMessage(popped, factory, []byte(str)); err != nil {
+			failed[strconv.Itoa(i)] = err
+		} else if !r.isDuplicate(popped, message) {
+			result = append(result, message)
+		}
+	}
+	if len(failed) > 0 && popErr != nil {
+		return result, false, errors.Join(popErr, &DecodeError{Key: popped, Failed: failed})
+	} else if len(failed) > 0 {
+		return result, false, &DecodeError{Key: popped, Failed: failed}
+	} else if popErr != nil {
+		return result, false, popErr
+	}
+	return result, len(result) == 0, nil
 }
 
 // CreateProducer creates a message producer for a specific topic.
 func (r *RedisAdapter) CreateProducer(topic string) (IMessageProducer, error) {
 	return &producer{
-		rc:    r.rc,
-		topic: topic,
+		rc:          r.rc,
+		topic:       topic,
+		ns:          r.ns,
+		serializer:  r.serializer,
+		middlewares: r.pubMiddlewares,
 	}, nil
 }
 
 // CreateConsumer creates a message consumer for a specific topic or a pattern.
+// The consumer is a *RedisConsumer, supporting context-aware (ReadContext) and channel-based (Messages) reads.
 func (r *RedisAdapter) CreateConsumer(subscription string, mf MessageFactory, topics ...string) (IMessageConsumer, error) {
 
 	topicArray := make([]string, 0)
@@ -185,22 +338,26 @@ func (r *RedisAdapter) CreateConsumer(subscription string, mf MessageFactory, to
 		if strings.Contains(t, "*") {
 			isPattern = true
 		}
-		topicArray = append(topicArray, t)
+		topicArray = append(topicArray, r.ns.key(t))
 	}
 
 	var ps *redis.PubSub
 
 	if isPattern {
-		ps = r.rc.PSubscribe(r.ctx, topics...)
+		ps = r.rc.PSubscribe(r.ctx, topicArray...)
 	} else {
-		ps = r.rc.Subscribe(r.ctx, topics...)
+		ps = r.rc.Subscribe(r.ctx, topicArray...)
 	}
 
-	return &consumer{
-		ps:        ps,
-		factory:   mf,
-		isPattern: isPattern,
-		topics:    topicArray,
+	return &RedisConsumer{
+		ps:          ps,
+		factory:     mf,
+		isPattern:   isPattern,
+		topics:      topicArray,
+		ns:          r.ns,
+		serializer:  r.serializer,
+		middlewares: append(r.dedupMiddlewares(subscription), r.subMiddlewares...),
+		done:        make(chan struct{}),
 	}, nil
 }
 
@@ -210,8 +367,11 @@ func (r *RedisAdapter) CreateConsumer(subscription string, mf MessageFactory, to
 
 // producer is a redis based implementation of the IMessageProducer interface.
 type producer struct {
-	rc    *redis.Client
-	topic string
+	rc          redis.UniversalClient
+	topic       string
+	ns          namespace
+	serializer  *serializer
+	middlewares []PublishMiddleware
 }
 
 // Close is a no-op for the redis producer.
@@ -221,84 +381,185 @@ func (p *producer) Close() error {
 
 // Publish publishes messages to the producer's topic.
 func (p *producer) Publish(messages ...IMessage) error {
+	publish := chainPublish(p.publish, p.middlewares)
 	for _, message := range messages {
-		if bytes, err := messageToRaw(message); err != nil {
+		if err := publish(message); err != nil {
 			return err
-		} else {
-			topic := message.Topic()
-			if topic == "" {
-				topic = p.topic
-			}
-			if res := p.rc.Publish(context.Background(), topic, bytes); res.Err() != nil {
-				return res.Err()
-			}
 		}
 	}
 	return nil
 }
 
+// publish publishes a message to its topic, or to the producer's topic if the message has none.
+func (p *producer) publish(message IMessage) error {
+	topic := message.Topic()
+	if topic == "" {
+		topic = p.topic
+	}
+	if bytes, err := p.serializer.messageToRaw(topic, message); err != nil {
+		return err
+	} else {
+		return p.rc.Publish(context.Background(), p.ns.key(topic), bytes).Err()
+	}
+}
+
 // endregion
 
 // region Consumer methods  --------------------------------------------------------------------------------------------
 
-// consumer is a redis based implementation of the IMessageConsumer interface.
-type consumer struct {
-	ps        *redis.PubSub
-	factory   MessageFactory
-	isPattern bool
-	topics    []string
+// ErrReadTimeout is returned by the consumer Read when no message arrives within the timeout
+// (and by ReadContext when the deadline of the context expires).
+var ErrReadTimeout = errors.New("read timeout")
+
+// ErrConsumerClosed is returned by the consumer reads once the consumer is closed.
+var ErrConsumerClosed = errors.New("consumer closed")
+
+// RedisConsumer is a redis based implementation of the IMessageConsumer interface (see CreateConsumer),
+// adding context-aware and channel-based reads.
+type RedisConsumer struct {
+	ps          *redis.PubSub
+	factory     MessageFactory
+	isPattern   bool
+	topics      []string
+	ns          namespace
+	serializer  *serializer
+	middlewares []SubscriptionMiddleware
+
+	messagesOnce sync.Once
+	messages     chan IMessage
+
+	closeOnce sync.Once
+	done      chan struct{} // closed when the consumer is closed
 }
 
-// Close unsubscribes the consumer from its topics.
-func (p *consumer) Close() error {
-
-	if p.ps == nil {
-		return nil
-	}
+// Close unsubscribes the consumer from its topics and releases its connection. Blocked reads return
+// ErrConsumerClosed, and the messages channel is closed. Closing a closed consumer is a no-op.
+func (p *RedisConsumer) Close() (err error) {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		if p.ps != nil {
+			err = p.ps.Close()
+		}
+	})
+	return err
+}
 
-	if p.isPattern {
-		return p.ps.PUnsubscribe(context.Background(), p.topics...)
-	} else {
-		return p.ps.Unsubscribe(context.Background(), p.topics...)
+// isClosed checks if the consumer is closed.
+func (p *RedisConsumer) isClosed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
 	}
 }
 
 // Read reads a message from the topic, blocking until a new message arrives or until the timeout expires.
-// Use 0 for an unlimited timeout.
+// Use 0 for an unlimited timeout. It returns ErrReadTimeout when the timeout expires, and ErrConsumerClosed
+// once the consumer is closed.
 // The standard way to use Read is within an infinite loop:
 //
 //	for {
-//		if msg, err := consumer.Read(time.Second * 5); err != nil {
+//		if msg, err := consumer.Read(time.Second * 5); errors.Is(err, facilities.ErrConsumerClosed) {
+//			return
+//		} else if err != nil {
 //			// Handle error
 //		} else {
 //			// Process message in a dedicated go routine
 //			go processThisMessage(msg)
 //		}
 //	}
-func (p *consumer) Read(timeout time.Duration) (IMessage, error) {
-
-	if timeout == 0 {
-		timeout = time.Hour * 24
+func (p *RedisConsumer) Read(timeout time.Duration) (IMessage, error) {
+	if timeout <= 0 {
+		return p.read(context.Background(), nil)
 	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return p.read(context.Background(), timer.C)
+}
 
-LOOP:
+// ReadContext reads a message from the topic, blocking until a new message arrives or until the context is done.
+// It returns an error matching both ErrReadTimeout and context.DeadlineExceeded when the deadline of the context
+// expires, context.Canceled when the context is canceled, and ErrConsumerClosed once the consumer is closed.
+func (p *RedisConsumer) ReadContext(ctx context.Context) (IMessage, error) {
+	return p.read(ctx, nil)
+}
+
+// Messages returns a channel delivering the messages of the topic, closed once the consumer is closed.
+// Messages that fail to decode are logged and skipped. The channel and Read share the received messages,
+// so a consumer should be read using one of them.
+func (p *RedisConsumer) Messages() <-chan IMessage {
+	p.messagesOnce.Do(func() {
+		p.messages = make(chan IMessage)
+		if p.isClosed() {
+			close(p.messages)
+			return
+		}
+		go func() {
+			defer close(p.messages)
+			for m := range p.ps.Channel() {
+				if message, err := p.decode(m); err != nil {
+					logger.Warn("Consumer: failed to process message on topic %s: %s", p.ns.strip(m.Channel), err.Error())
+				} else if message != nil {
+					select {
+					case p.messages <- message:
+					case <-p.done:
+						return
+					}
+				}
+			}
+		}()
+	})
+	return p.messages
+}
+
+// read waits for a message until the context is done or the timer expires.
+// Messages dropped by a middleware are skipped, within the same wait.
+func (p *RedisConsumer) read(ctx context.Context, expired <-chan time.Time) (IMessage, error) {
+	if p.isClosed() {
+		return nil, ErrConsumerClosed
+	}
 	for {
 		select {
-		case m := <-p.ps.Channel():
-			if m == nil {
-				break LOOP
+		case <-p.done:
+			return nil, ErrConsumerClosed
+		case m, ok := <-p.ps.Channel():
+			if !ok || m == nil {
+				return nil, ErrConsumerClosed
 			}
-			message := p.factory()
-			if err := Unmarshal([]byte(m.Payload), &message); err != nil {
-				return nil, err
+			if message, err := p.decode(m); err != nil || message != nil {
+				return message, err
+			}
+		case <-ctx.Done():
+			if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %w", ErrReadTimeout, err)
 			} else {
-				return message, nil
+				return nil, err
 			}
-		case <-time.After(timeout):
-			return nil, fmt.Errorf("read timeout")
+		case <-expired:
+			return nil, ErrReadTimeout
 		}
 	}
-	return nil, fmt.Errorf("read timeout")
+}
+
+// decode converts a received message and runs the middlewares of the adapter on it.
+// It returns nil if the message was dropped by a middleware.
+func (p *RedisConsumer) decode(m *redis.Message) (IMessage, error) {
+	message, err := p.serializer.rawToMessage(p.ns.strip(m.Channel), p.factory, []byte(m.Payload))
+	if err != nil || len(p.middlewares) == 0 {
+		return message, err
+	}
+	return p.deliver(message), nil
+}
+
+// deliver runs the middlewares of the adapter on a received message.
+// It returns the message passed to the last middleware, or nil if the message was dropped.
+func (p *RedisConsumer) deliver(message IMessage) (delivered IMessage) {
+	chainSubscription(func(msg IMessage) bool {
+		delivered = msg
+		return true
+	}, p.middlewares)(message)
+	return delivered
 }
 
 // endregion
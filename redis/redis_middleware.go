@@ -0,0 +1,67 @@
+// Middleware chains wrapping the message bus operations of the redis implementation of IMessageBus
+//
+// Middlewares implement cross-cutting concerns (logging, metrics, tracing, validation, ...) once, instead of in every
+// callback. They are registered per adapter (applied to all the subscriptions, consumers and producers of the adapter
+// and of its clones) or per subscription. The first registered middleware is the outermost one, and the adapter
+// middlewares wrap the subscription ones.
+
+package facilities
+
+import (
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// SubscriptionMiddleware wraps the processing of received messages: the callback of a subscription, or the delivery
+// of a message by IMessageConsumer.Read. A middleware may drop a message by not calling the next handler: a dropped
+// message is not delivered to the callback, and is skipped by Read. With Read, the next handler returns as soon as
+// the message is handed over to the reader (before it is processed).
+type SubscriptionMiddleware func(next SubscriptionCallback) SubscriptionCallback
+
+// PublishFunc sends a message (see PublishMiddleware).
+type PublishFunc func(message IMessage) error
+
+// PublishMiddleware wraps the sending of every message by Publish, Push and IMessageProducer.Publish.
+// A middleware may reject a message by returning an error without calling the next function. The next function
+// returns once the message is sent, including for the messages of a batch (PublishBatch, PushBatch, and Publish or
+// Push with several messages), whose middlewares run concurrently; calling it again (e.g. to retry) sends the message
+// on its own, except in an atomic batch where it returns ErrBatchRetry.
+type PublishMiddleware func(next PublishFunc) PublishFunc
+
+// WithSubscriptionMiddleware adds middlewares to all the subscriptions and consumers of the adapter.
+func WithSubscriptionMiddleware(middlewares ...SubscriptionMiddleware) Option {
+	return func(r *RedisAdapter) {
+		r.subMiddlewares = append(r.subMiddlewares, middlewares...)
+	}
+}
+
+// WithPublishMiddleware adds middlewares to all the messages sent by the adapter and its producers.
+func WithPublishMiddleware(middlewares ...PublishMiddleware) Option {
+	return func(r *RedisAdapter) {
+		r.pubMiddlewares = append(r.pubMiddlewares, middlewares...)
+	}
+}
+
+// WithMiddleware adds middlewares to a subscription, inside the middlewares of the adapter.
+func WithMiddleware(middlewares ...SubscriptionMiddleware) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// chainSubscription wraps a callback with middlewares, the first middleware being the outermost one.
+func chainSubscription(callback SubscriptionCallback, middlewares ...[]SubscriptionMiddleware) SubscriptionCallback {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		for j := len(middlewares[i]) - 1; j >= 0; j-- {
+			callback = middlewares[i][j](callback)
+		}
+	}
+	return callback
+}
+
+// chainPublish wraps a send function with middlewares, the first middleware being the outermost one.
+func chainPublish(send PublishFunc, middlewares []PublishMiddleware) PublishFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		send = middlewares[i](send)
+	}
+	return send
+}
@@ -0,0 +1,71 @@
+// Key namespace isolation for the redis implementation of IDataCache and IMessageBus
+//
+
+package facilities
+
+import "strings"
+
+// namespaceSeparator separates the namespace from the key, channel or queue name
+const namespaceSeparator = ":"
+
+// reservedPrefix is the prefix of the internal keys of the adapter (e.g. tag indexes, load locks and replies),
+// which are skipped by ScanKeys and DelPattern unless the pattern explicitly starts with it.
+const reservedPrefix = "yaaf:"
+
+// WithNamespace isolates the adapter in a namespace, so several services can share the same redis database:
+// every key, hash, list, lock key, tag index, pub/sub channel and queue is transparently prefixed with the
+// namespace followed by a colon (e.g. "orders:hero:1"). The prefix is stripped from the keys returned by the adapter,
+// and codecs and encryption are selected by the key or topic name without the namespace.
+func WithNamespace(name string) Option {
+	return func(r *RedisAdapter) {
+		if name = strings.TrimSuffix(name, namespaceSeparator); name == "" {
+			r.ns = ""
+		} else {
+			r.ns = namespace(name + namespaceSeparator)
+		}
+	}
+}
+
+// Namespace returns the namespace of the adapter (empty if no namespace is configured, see WithNamespace).
+func (r *RedisAdapter) Namespace() string {
+	return strings.TrimSuffix(string(r.ns), namespaceSeparator)
+}
+
+// namespace is the prefix (including the separator) added to the keys, channels and queues of the adapter.
+type namespace string
+
+// key converts a key, channel or queue name to its name in redis.
+func (n namespace) key(key string) string {
+	return string(n) + key
+}
+
+// keys converts a list of keys, channels or queue names to their names in redis.
+func (n namespace) keys(keys []string) []string {
+	if n == "" {
+		return keys
+	}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, n.key(key))
+	}
+	return result
+}
+
+// pattern converts a match pattern to a pattern matching only the keys of the namespace ("" matches all of them).
+func (n namespace) pattern(match string) string {
+	if n != "" && match == "" {
+		return n.key("*")
+	}
+	return n.key(match)
+}
+
+// isReserved checks if a key (without the namespace) is an internal key of the adapter, not matched explicitly
+// by a pattern.
+func isReserved(key, match string) bool {
+	return strings.HasPrefix(key, reservedPrefix) && !strings.HasPrefix(match, reservedPrefix)
+}
+
+// strip converts a key, channel or queue name in redis to its name in the namespace.
+func (n namespace) strip(key string) string {
+	return strings.TrimPrefix(key, string(n))
+}
@@ -0,0 +1,177 @@
+// Queue inspection and administration for the redis implementation of IMessageBus
+//
+// Queues are lists: Push appends messages at the head (LPush) and Pop removes them from the tail (RPop),
+// so the tail of the list holds the next message to be popped.
+
+package facilities
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+// queueScanPageSize is the number of messages read per round trip when scanning a queue
+const queueScanPageSize = 1000
+
+// ErrSameQueue is returned when moving the messages of a queue to itself
+var ErrSameQueue = errors.New("source and destination queues are the same")
+
+// QueueLength returns the number of messages in a queue (0 if the queue does not exist).
+func (r *RedisAdapter) QueueLength(queue string) (int64, error) {
+	return r.rc.LLen(r.ctx, r.ns.key(queue)).Result()
+}
+
+// PeekQueue gets up to count messages of a queue without removing them, in the order they would be popped.
+// Messages that fail to decode are reported by a DecodeError (by position in the queue, 0 being the next message),
+// along with the messages that were decoded successfully.
+func (r *RedisAdapter) PeekQueue(factory MessageFactory, queue string, count int64) ([]IMessage, error) {
+	if count <= 0 {
+		return []IMessage{}, nil
+	}
+	list, err := r.rc.LRange(r.ctx, r.ns.key(queue), -count, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]IMessage, 0, len(list))
+	failed := make(map[string]error)
+	for i := len(list) - 1; i >= 0; i-- {
+		if message, er := r.serializer.rawToMessage(queue, factory, []byte(list[i])); er != nil {
+			failed[strconv.Itoa(len(list)-1-i)] = er
+		} else {
+			result = append(result, message)
+		}
+	}
+	if len(failed) > 0 {
+		return result, &DecodeError{Key: queue, Failed: failed}
+	}
+	return result, nil
+}
+
+// RemoveFromQueue removes the messages of a queue matching a predicate, and returns the number of removed messages.
+// Messages that fail to decode are never removed. The queue is read in pages, so messages pushed or popped
+// concurrently may be missed, but only messages that matched the predicate are removed.
+func (r *RedisAdapter) RemoveFromQueue(factory MessageFactory, queue string, match func(message IMessage) bool) (int64, error) {
+	key := r.ns.key(queue)
+	matched := make(map[string]int64)
+	for start := int64(0); ; start += queueScanPageSize {
+		list, err := r.rc.LRange(r.ctx, key, start, start+queueScanPageSize-1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, str := range list {
+			if message, er := r.serializer.rawToMessage(queue, factory, []byte(str)); er == nil && match(message) {
+				matched[str]++
+			}
+		}
+		if len(list) < queueScanPageSize {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+
+	cmds, err := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for str, count := range matched {
+			pipe.LRem(r.ctx, key, count, str)
+		}
+		return nil
+	})
+	var removed int64
+	for _, cmd := range cmds {
+		removed += cmd.(*redis.IntCmd).Val()
+	}
+	return removed, err
+}
+
+// PurgeQueue removes all the messages of a queue, and returns the number of removed messages.
+func (r *RedisAdapter) PurgeQueue(queue string) (int64, error) {
+	key := r.ns.key(queue)
+	var length *redis.IntCmd
+	if _, err := r.rc.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.LLen(r.ctx, key)
+		pipe.Unlink(r.ctx, key)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return length.Val(), nil
+}
+
+// MoveQueue moves up to count messages (0 for all the messages) from a queue to another, in the order they would be
+// popped, and returns the number of moved messages. Every message is moved atomically (using LMove, which requires
+// redis 6.2), so a message is never lost or duplicated, and the moves are pipelined by pages. Moving all the messages moves at most the messages in the
+// source queue when the move starts, so it ends while producers keep pushing to it. On a Redis Cluster, both queues
+// must be in the same hash slot.
+func (r *RedisAdapter) MoveQueue(source, destination string, count int64) (int64, error) {
+	if source == destination {
+		return 0, ErrSameQueue
+	}
+	if count <= 0 {
+		length, err := r.rc.LLen(r.ctx, r.ns.key(source)).Result()
+		if err != nil {
+			return 0, err
+		}
+		count = length
+	}
+
+	var moved int64
+	for moved < count {
+		page := min(count-moved, queueScanPageSize)
+		// The errors are reported by the commands
+		cmds, _ := r.rc.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			for i := int64(0); i < page; i++ {
+				pipe.LMove(r.ctx, r.ns.key(source), r.ns.key(destination), "RIGHT", "LEFT")
+			}
+			return nil
+		})
+
+		// A message pushed to the source while the page is moved may still be moved after it was found empty
+		empty := false
+		var err error
+		for _, cmd := range cmds {
+			if er := cmd.Err(); er == nil {
+				moved++
+			} else if errors.Is(er, redis.Nil) {
+				empty = true
+			} else if err == nil {
+				err = er
+			}
+		}
+		if err != nil {
+			return moved, err
+		} else if empty {
+			break
+		}
+	}
+	return moved, nil
+}
+
+// ListQueues returns the sorted names of the queues matching a pattern (use "" to match all the queues).
+// Every list is considered a queue, including the lists of the data cache, except the internal lists of the adapter
+// (e.g. the request/reply lists), whose names start with the reserved "yaaf:" prefix. Empty queues do not exist in
+// redis.
+func (r *RedisAdapter) ListQueues(pattern string) ([]string, error) {
+	found := make(map[string]bool)
+	for key, err := range r.ScanKeys(pattern, "list", queueScanPageSize) {
+		if err != nil {
+			return nil, err
+		} else if !strings.HasPrefix(key, reservedPrefix) {
+			found[key] = true
+		}
+	}
+
+	queues := make([]string, 0, len(found))
+	for queue := range found {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
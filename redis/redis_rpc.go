@@ -0,0 +1,287 @@
+// Request/reply (RPC) over the redis implementation of IMessageBus
+//
+// A request is published (or pushed) to a topic in an envelope whose headers hold a correlation id and a deadline.
+// The responder sends the reply to a private list derived from the correlation id, on which the requester waits.
+// The reply list expires shortly after the deadline, so replies sent after the requester gave up are cleaned up.
+
+package facilities
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/logger"
+	. "github.com/go-yaaf/yaaf-common/messaging"
+)
+
+const (
+	// replyPrefix is the prefix of the private reply lists
+	replyPrefix = "yaaf:rpc:reply:"
+
+	// replyGrace is the time a reply list is kept after the request deadline, covering clock skew between the hosts
+	replyGrace = 5 * time.Second
+
+	// queuePollTimeout is the maximum time a queue responder blocks waiting for a request
+	queuePollTimeout = time.Second
+
+	// replyPollInterval is the interval at which a requester polls for the reply during the last (sub-second) part
+	// of its timeout, which BLPOP cannot wait for
+	replyPollInterval = 50 * time.Millisecond
+)
+
+// ErrRequestTimeout is returned by Request when no reply is received within the timeout.
+var ErrRequestTimeout = errors.New("request timeout")
+
+// RemoteError is returned by Request when the responder handler failed.
+type RemoteError struct {
+	Message string // The error message of the responder
+}
+
+// Error returns the error message.
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error: %s", e.Message)
+}
+
+// RequestHandler processes a request and returns the reply (see Respond). A returned error is sent to the requester
+// as a RemoteError.
+type RequestHandler func(request IMessage) (IMessage, error)
+
+// RequestOption configures a request (see Request).
+type RequestOption func(*requestOptions)
+
+// requestOptions holds the request configuration.
+type requestOptions struct {
+	queue bool // push the request to a queue instead of publishing it
+}
+
+// WithRequestQueue pushes the request to a queue (served by RespondQueue) instead of publishing it to a topic
+// (served by Respond), so it is processed by a single responder even if none is listening yet.
+func WithRequestQueue() RequestOption {
+	return func(o *requestOptions) {
+		o.queue = true
+	}
+}
+
+// Request sends a request message to its topic and waits up to the timeout for the reply, decoded using the factory.
+// The request is published (see Respond) or pushed to a queue (see WithRequestQueue, RespondQueue). It returns
+// ErrRequestTimeout if no reply is received within the timeout, or a RemoteError if the responder failed.
+func (r *RedisAdapter) Request(message IMessage, factory MessageFactory, timeout time.Duration, options ...RequestOption) (IMessage, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("request timeout must be positive")
+	}
+	opts := requestOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	topic := message.Topic()
+	id := NanoID()
+	deadline := time.Now().Add(timeout)
+	headers := Headers{
+		HeaderCorrelationId: id,
+		headerDeadline:      strconv.FormatInt(deadline.UnixMilli(), 10),
+	}
+	replyKey := r.ns.key(replyPrefix + id)
+
+	send := func(msg IMessage) error {
+		if data, err := r.serializer.messageToEnvelope(topic, msg, headers); err != nil {
+			return err
+		} else if opts.queue {
+			return r.rc.LPush(r.ctx, r.ns.key(topic), data).Err()
+		} else {
+			return r.rc.Publish(r.ctx, r.ns.key(topic), data).Err()
+		}
+	}
+	if err := chainPublish(send, r.pubMiddlewares)(message); err != nil {
+		return nil, err
+	}
+
+	result, err := r.awaitReply(replyKey, deadline)
+	if errors.Is(err, redis.Nil) {
+		// A reply sent after the timeout expired would remain until the reply list expires
+		_ = r.rc.Del(r.ctx, replyKey).Err()
+		return nil, ErrRequestTimeout
+	} else if err != nil {
+		return nil, err
+	}
+
+	reply, body, err := decodeEnvelope([]byte(result))
+	if err != nil {
+		return nil, err
+	} else if reply[HeaderCorrelationId] != id {
+		return nil, fmt.Errorf("invalid reply to request %s", id)
+	} else if reply[headerError] != "" {
+		return nil, &RemoteError{Message: reply[headerError]}
+	} else if len(body) == 0 {
+		return nil, nil
+	}
+	// The envelope is decoded once, the reply is built from its body
+	response, err := r.serializer.bodyToMessage(topic, factory, body)
+	if err != nil {
+		return nil, err
+	}
+	if carrier, ok := response.(HeadersCarrier); ok {
+		carrier.SetHeaders(reply)
+	}
+	return response, nil
+}
+
+// awaitReply waits for the reply of a request until the deadline, and returns redis.Nil if it did not arrive.
+// BLPOP waits for whole seconds only, so the last (sub-second) part of the timeout is waited by polling.
+func (r *RedisAdapter) awaitReply(replyKey string, deadline time.Time) (string, error) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return "", redis.Nil
+		}
+		if wait := remaining.Truncate(time.Second); wait > 0 {
+			if result, err := r.rc.BLPop(r.ctx, wait, replyKey).Result(); err == nil {
+				return result[1], nil
+			} else if !errors.Is(err, redis.Nil) {
+				return "", err
+			}
+			continue
+		}
+		if result, err := r.rc.LPop(r.ctx, replyKey).Result(); !errors.Is(err, redis.Nil) {
+			return result, err
+		}
+		time.Sleep(min(replyPollInterval, remaining))
+	}
+}
+
+// Respond subscribes to topics and invokes the handler for each received request, sending its reply to the requester.
+// Messages sent without Request are processed too, and their reply is discarded. Requests whose deadline expired are
+// skipped. The responder supports the subscription options (e.g. WithConcurrency), and is removed using Unsubscribe.
+func (r *RedisAdapter) Respond(subscriberName string, factory MessageFactory, handler RequestHandler, topics []string, options ...SubscriptionOption) (string, error) {
+	return r.subscribe(subscriberName, topics, newSubscriptionOptions(options...), func(s *subscriber) {
+		r.receive(s, func(m *redis.Message) {
+			r.serve(s, r.ns.strip(m.Channel), []byte(m.Payload), factory, handler)
+		})
+	})
+}
+
+// RespondQueue is like Respond, for requests pushed to queues (see WithRequestQueue): every request is processed by
+// a single responder. On a Redis Cluster, all the queues must belong to the same hash slot (use a hash tag).
+func (r *RedisAdapter) RespondQueue(subscriberName string, factory MessageFactory, handler RequestHandler, queues []string, options ...SubscriptionOption) (string, error) {
+	opts := newSubscriptionOptions(options...)
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	subscriptionId := NanoID()
+	s := r.newSubscriber(subscriptionId, subscriberName, nil, r.ns.keys(queues), false, opts)
+
+	r.Lock()
+	defer r.Unlock()
+	r.subs[subscriptionId] = s
+	go r.queueResponder(s, factory, handler)
+	return subscriptionId, nil
+}
+
+// queueResponder is a function running a loop to pop requests from queues, until the subscription is closed.
+func (r *RedisAdapter) queueResponder(s *subscriber, factory MessageFactory, handler RequestHandler) {
+	backoff := minBackoff
+	for !s.isClosed() {
+		result, err := r.rc.BRPop(r.ctx, queuePollTimeout, s.topics...).Result()
+		if s.isClosed() {
+			return
+		}
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			s.setState(SubscriptionReconnecting, err)
+			logger.Warn("RespondQueue: failed to pop request: %s", err.Error())
+			select {
+			case <-s.done:
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, s.options.maxBackoff)
+			continue
+		}
+
+		backoff = minBackoff
+		s.setState(SubscriptionActive, nil)
+		s.received()
+		r.serve(s, r.ns.strip(result[0]), []byte(result[1]), factory, handler)
+	}
+}
+
+// serve decodes a request and dispatches its handler, sending the reply (or the error) to the requester.
+func (r *RedisAdapter) serve(s *subscriber, topic string, payload []byte, factory MessageFactory, handler RequestHandler) {
+	message, headers, err := r.serializer.rawToEnvelope(topic, factory, payload)
+	if headers[HeaderCorrelationId] != "" && time.Now().After(headers.deadline()) {
+		logger.Warn("Respond: skipping request %s on topic %s, its deadline expired", headers[HeaderCorrelationId], topic)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to unmarshal message: %w", err)
+		r.messageError(s, topic, payload, err)
+		r.reply(topic, headers, nil, err)
+		return
+	}
+
+	s.handlers.dispatch(s.options.messageKey(message), func() {
+		r.invoke(s, topic, payload, func() {
+			var response IMessage
+			var er error
+			handled := false
+			defer func() {
+				if value := recover(); value != nil {
+					r.reply(topic, headers, nil, fmt.Errorf("responder panic: %v", value))
+					panic(value)
+				}
+				if handled {
+					r.reply(topic, headers, response, er)
+				}
+			}()
+			chainSubscription(func(msg IMessage) bool {
+				response, er = handler(msg)
+				handled = true
+				return er == nil
+			}, r.subMiddlewares, s.options.middlewares)(message)
+		})
+	})
+}
+
+// reply sends the reply of a request to its reply list, unless the message is not a request (sent without Request)
+// or the requester no longer waits for it.
+func (r *RedisAdapter) reply(topic string, request Headers, response IMessage, err error) {
+	id := request[HeaderCorrelationId]
+	if id == "" {
+		return
+	}
+	ttl := time.Until(request.deadline())
+	if ttl <= 0 {
+		logger.Warn("Respond: discarding the reply to request %s, its deadline expired", id)
+		return
+	}
+
+	headers := Headers{HeaderCorrelationId: id}
+	var data []byte
+	if err == nil && response != nil {
+		data, err = r.serializer.messageToEnvelope(topic, response, headers)
+	}
+	if err != nil {
+		headers[headerError] = err.Error()
+	}
+	if data == nil {
+		data, err = encodeEnvelope(headers, nil)
+	}
+	if err != nil {
+		logger.Warn("Respond: failed to encode the reply to request %s: %s", id, err.Error())
+		return
+	}
+
+	replyKey := r.ns.key(replyPrefix + id)
+	if _, err = r.rc.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(r.ctx, replyKey, data)
+		pipe.PExpire(r.ctx, replyKey, ttl+replyGrace)
+		return nil
+	}); err != nil {
+		logger.Warn("Respond: failed to send the reply to request %s: %s", id, err.Error())
+	}
+}
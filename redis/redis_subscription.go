@@ -0,0 +1,370 @@
+// Subscription lifecycle (confirmation, health checks and resubscription) for the redis implementation of IMessageBus
+//
+
+package facilities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/go-yaaf/yaaf-common/logger"
+)
+
+const (
+	defaultHealthCheckInterval = 15 * time.Second
+	defaultMaxBackoff          = 5 * time.Second
+	minBackoff                 = 100 * time.Millisecond
+	subscribeTimeout           = 5 * time.Second // the time redis has to confirm a subscription
+)
+
+// SubscriptionState is the health state of a subscription.
+type SubscriptionState int
+
+const (
+	SubscriptionActive       SubscriptionState = iota // The subscription is receiving messages
+	SubscriptionReconnecting                          // The connection was lost, the subscription is being re-established
+	SubscriptionLost                                  // The subscription could not be re-established (see WithResubscribe)
+	SubscriptionClosed                                // The subscription was removed using Unsubscribe
+)
+
+// String returns the state name.
+func (s SubscriptionState) String() string {
+	switch s {
+	case SubscriptionActive:
+		return "active"
+	case SubscriptionReconnecting:
+		return "reconnecting"
+	case SubscriptionLost:
+		return "lost"
+	case SubscriptionClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// SubscriptionHealth is a snapshot of the health of a subscription.
+type SubscriptionHealth struct {
+	State       SubscriptionState // The current state
+	LastMessage time.Time         // The time the last message was received (zero if no message was received)
+	Reconnects  int               // The number of times the subscription was re-established after a connection loss
+	LastError   error             // The last connection error (nil if no error occurred)
+	Received    int64             // The number of messages received
+	Failed      int64             // The number of messages which failed to decode or whose callback panicked
+	Dropped     int64             // The number of messages dropped by the overflow policy (see WithOverflow)
+}
+
+// SubscriptionLostHandler is invoked when a subscription is permanently lost (see WithResubscribe).
+type SubscriptionLostHandler func(subscriptionId string, err error)
+
+// SubscriptionOption configures a subscription (see SubscribeWithOptions).
+type SubscriptionOption func(*subscriptionOptions)
+
+// subscriptionOptions holds the subscription configuration.
+type subscriptionOptions struct {
+	healthCheck time.Duration            // idle time after which the connection is checked using PING
+	maxAttempts int                      // maximum number of consecutive resubscribe attempts (0 means unlimited)
+	maxBackoff  time.Duration            // maximum delay between resubscribe attempts
+	onLost      SubscriptionLostHandler  // invoked when the subscription is permanently lost
+	concurrency int                      // maximum number of concurrent callbacks (or workers when ordered by key)
+	ordering    OrderingMode             // the order in which messages are processed
+	orderingKey MessageKeyFunc           // the ordering key of a message (OrderByKey)
+	overflow    OverflowPolicy           // the policy applied when the pending buffer is full
+	bufferSize  int                      // maximum number of messages waiting for a worker
+	onError     MessageErrorHandler      // invoked when a message cannot be decoded or its callback panics
+	errorTopic  string                   // the topic failed messages are forwarded to
+	middlewares []SubscriptionMiddleware // wrap the callback, inside the middlewares of the adapter
+}
+
+// newSubscriptionOptions creates the subscription configuration with its defaults.
+func newSubscriptionOptions(options ...SubscriptionOption) subscriptionOptions {
+	opts := subscriptionOptions{healthCheck: defaultHealthCheckInterval, maxBackoff: defaultMaxBackoff, concurrency: maxConcurrentHandlers}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	return opts
+}
+
+// WithHealthCheck sets the idle time after which the subscription connection is checked using PING (15 seconds by default).
+// A connection which does not answer within another interval is considered lost and the subscription is re-established.
+func WithHealthCheck(interval time.Duration) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		if interval > 0 {
+			o.healthCheck = interval
+		}
+	}
+}
+
+// WithResubscribe sets the maximum number of consecutive attempts to re-establish a lost subscription
+// (0, the default, retries forever), and the maximum delay between attempts (exponential backoff, 5 seconds by default).
+// Once all the attempts fail, the subscription is permanently lost.
+func WithResubscribe(maxAttempts int, maxBackoff time.Duration) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.maxAttempts = maxAttempts
+		if maxBackoff > 0 {
+			o.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithSubscriptionLost sets the handler invoked when the subscription is permanently lost.
+func WithSubscriptionLost(handler SubscriptionLostHandler) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.onLost = handler
+	}
+}
+
+// SubscriptionHealth returns the health of a subscription by its ID, or false if the subscription does not exist.
+func (r *RedisAdapter) SubscriptionHealth(subscriptionId string) (SubscriptionHealth, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if s, ok := r.subs[subscriptionId]; !ok {
+		return SubscriptionHealth{}, false
+	} else {
+		return s.health(), true
+	}
+}
+
+// region Subscriber ---------------------------------------------------------------------------------------------------
+
+// subscriber is a private struct to hold subscription details and health
+type subscriber struct {
+	id        string
+	name      string
+	topics    []string // the channels (or patterns, or queues) in redis, including the namespace
+	isPattern bool
+	options   subscriptionOptions
+	handlers  *dispatcher   // runs the callbacks of the subscription
+	done      chan struct{} // closed when the subscription is removed
+
+	mu            sync.Mutex
+	ps            *redis.PubSub // nil for queue subscriptions (see RespondQueue)
+	state         SubscriptionState
+	lastMessage   time.Time
+	reconnects    int
+	lastError     error
+	receivedCount int64
+	failedCount   int64
+}
+
+// newSubscriber creates a subscriber for an established PubSub.
+func (r *RedisAdapter) newSubscriber(id, name string, ps *redis.PubSub, topics []string, isPattern bool, options subscriptionOptions) *subscriber {
+	return &subscriber{
+		id:        id,
+		name:      name,
+		topics:    topics,
+		isPattern: isPattern,
+		options:   options,
+		handlers:  newDispatcher(&r.inFlight, options),
+		done:      make(chan struct{}),
+		ps:        ps,
+		state:     SubscriptionActive,
+	}
+}
+
+// health returns a snapshot of the subscription health.
+func (s *subscriber) health() SubscriptionHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SubscriptionHealth{
+		State:       s.state,
+		LastMessage: s.lastMessage,
+		Reconnects:  s.reconnects,
+		LastError:   s.lastError,
+		Received:    s.receivedCount,
+		Failed:      s.failedCount,
+		Dropped:     s.handlers.dropped.Load(),
+	}
+}
+
+// received records the reception of a message.
+func (s *subscriber) received() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMessage = time.Now()
+	s.receivedCount++
+}
+
+// failed records a message which could not be processed.
+func (s *subscriber) failed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failedCount++
+}
+
+// setState changes the subscription state, unless it was closed.
+func (s *subscriber) setState(state SubscriptionState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == SubscriptionClosed {
+		return
+	}
+	if err != nil {
+		s.lastError = err
+	}
+	s.state = state
+}
+
+// replace replaces the PubSub of a re-established subscription. It returns false if the subscription was closed.
+func (s *subscriber) replace(ps *redis.PubSub) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == SubscriptionClosed {
+		return false
+	}
+	s.ps = ps
+	s.state = SubscriptionActive
+	s.reconnects++
+	return true
+}
+
+// close unsubscribes from the topics and releases the connection.
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == SubscriptionClosed {
+		return
+	}
+	released := s.state == SubscriptionReconnecting || s.state == SubscriptionLost
+	s.state = SubscriptionClosed
+	close(s.done)
+	if released || s.ps == nil {
+		// The connection of a lost subscription is already released, and queue subscriptions have none
+		return
+	}
+
+	var err error
+	if s.isPattern {
+		err = s.ps.PUnsubscribe(context.Background(), s.topics...)
+	} else {
+		err = s.ps.Unsubscribe(context.Background(), s.topics...)
+	}
+	if err != nil {
+		logger.Warn("Unsubscribe error unsubscribe: %s\n", err.Error())
+	}
+	if err = s.ps.Close(); err != nil {
+		logger.Warn("Unsubscribe error closing PubSub: %s\n", err.Error())
+	}
+}
+
+// isClosed checks if the subscription was removed.
+func (s *subscriber) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// endregion
+
+// region Receive loop -------------------------------------------------------------------------------------------------
+
+// openPubSub subscribes to channels (or patterns) and waits for the subscription to be confirmed by redis, failing if
+// it is not confirmed within subscribeTimeout (e.g. on a half-open connection).
+func (r *RedisAdapter) openPubSub(channels []string, isPattern bool) (*redis.PubSub, error) {
+	var ps *redis.PubSub
+	if isPattern {
+		ps = r.rc.PSubscribe(r.ctx, channels...)
+	} else {
+		ps = r.rc.Subscribe(r.ctx, channels...)
+	}
+	if _, err := ps.ReceiveTimeout(r.ctx, subscribeTimeout); err != nil {
+		_ = ps.Close()
+		if isTimeout(err) {
+			return nil, fmt.Errorf("subscription not confirmed within %s: %w", subscribeTimeout, err)
+		}
+		return nil, err
+	}
+	return ps, nil
+}
+
+// receive is a function running an infinite loop to get messages of a subscription, until it is closed or lost.
+// An idle connection is checked using PING, and a lost connection is replaced by a new subscription.
+func (r *RedisAdapter) receive(s *subscriber, handler func(m *redis.Message)) {
+
+	s.mu.Lock()
+	ps := s.ps
+	s.mu.Unlock()
+
+	pingSent := false
+	for {
+		msg, err := ps.ReceiveTimeout(r.ctx, s.options.healthCheck)
+		if s.isClosed() {
+			return
+		}
+		if err != nil {
+			if isTimeout(err) && !pingSent {
+				// Idle connection: check it is still alive, the PONG is expected within the next interval
+				if err = ps.Ping(r.ctx); err == nil {
+					pingSent = true
+					continue
+				}
+			}
+			if ps = r.resubscribe(s, ps, err); ps == nil {
+				return
+			}
+			pingSent = false
+			continue
+		}
+
+		pingSent = false
+		if m, ok := msg.(*redis.Message); ok {
+			s.received()
+			handler(m)
+		}
+	}
+}
+
+// resubscribe replaces the PubSub of a subscription whose connection was lost, retrying with exponential backoff.
+// It returns nil if the subscription was closed, or permanently lost once all the attempts fail.
+func (r *RedisAdapter) resubscribe(s *subscriber, ps *redis.PubSub, reason error) *redis.PubSub {
+	_ = ps.Close()
+	s.setState(SubscriptionReconnecting, reason)
+	logger.Warn("Subscribe: subscription %s lost its connection, resubscribing: %s", s.id, reason.Error())
+
+	backoff := minBackoff
+	for attempt := 1; s.options.maxAttempts <= 0 || attempt <= s.options.maxAttempts; attempt++ {
+		select {
+		case <-s.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.options.maxBackoff {
+			backoff = s.options.maxBackoff
+		}
+
+		if ps, reason = r.openPubSub(s.topics, s.isPattern); reason != nil {
+			s.setState(SubscriptionReconnecting, reason)
+			continue
+		}
+		if !s.replace(ps) {
+			_ = ps.Close()
+			return nil
+		}
+		return ps
+	}
+
+	err := fmt.Errorf("subscription lost after %d attempts: %w", s.options.maxAttempts, reason)
+	s.setState(SubscriptionLost, err)
+	logger.Error("Subscribe: subscription %s lost: %s", s.id, reason.Error())
+	if s.options.onLost != nil {
+		s.options.onLost(s.id, err)
+	}
+	return nil
+}
+
+// isTimeout checks if a receive error is a read timeout (no message was received within the timeout).
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// endregion
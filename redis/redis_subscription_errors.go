@@ -0,0 +1,84 @@
+// Panic recovery and error reporting of subscription callbacks
+//
+// A message which cannot be decoded, or whose callback panics, is reported to the error handler of the subscription
+// (or logged if there is none), and optionally forwarded as-is to an error topic for later inspection or replay.
+
+package facilities
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/go-yaaf/yaaf-common/logger"
+)
+
+// MessageErrorHandler is invoked when a received message cannot be processed: the raw payload could not be
+// decoded, or the callback panicked (reported as a *CallbackPanicError).
+type MessageErrorHandler func(topic string, payload []byte, err error)
+
+// CallbackPanicError reports a panic recovered in a subscription callback.
+type CallbackPanicError struct {
+	Value any    // The value passed to panic
+	Stack []byte // The stack trace of the panicking goroutine
+}
+
+// Error returns the error message.
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("subscription callback panic: %v", e.Value)
+}
+
+// WithErrorHandler sets the handler invoked when a received message cannot be decoded or its callback panics.
+// By default, these errors are logged.
+func WithErrorHandler(handler MessageErrorHandler) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.onError = handler
+	}
+}
+
+// WithErrorTopic forwards the raw payload of messages which cannot be decoded, or whose callback panics, to a topic
+// (a dead-letter topic). The topic must not match the topics of the subscription, or failed messages are received again.
+func WithErrorTopic(topic string) SubscriptionOption {
+	return func(o *subscriptionOptions) {
+		o.errorTopic = topic
+	}
+}
+
+// invoke runs the callback of a message, recovering from a panic and reporting it as a message error.
+func (r *RedisAdapter) invoke(s *subscriber, topic string, payload []byte, callback func()) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.messageError(s, topic, payload, &CallbackPanicError{Value: value, Stack: debug.Stack()})
+		}
+	}()
+	callback()
+}
+
+// messageError reports a message which could not be processed to the error handler of the subscription,
+// and forwards it to the error topic (if configured).
+func (r *RedisAdapter) messageError(s *subscriber, topic string, payload []byte, err error) {
+	s.failed()
+	if s.options.onError != nil {
+		r.invokeErrorHandler(s, topic, payload, err)
+	} else if panicErr, ok := err.(*CallbackPanicError); ok {
+		logger.Error("Subscribe: %s on topic %s\n%s", panicErr.Error(), topic, string(panicErr.Stack))
+	} else {
+		logger.Warn("Subscribe: failed to process message on topic %s: %s", topic, err.Error())
+	}
+
+	if s.options.errorTopic == "" || s.options.errorTopic == topic {
+		return
+	}
+	if er := r.rc.Publish(r.ctx, r.ns.key(s.options.errorTopic), payload).Err(); er != nil {
+		logger.Warn("Subscribe: failed to forward message from topic %s to error topic %s: %s", topic, s.options.errorTopic, er.Error())
+	}
+}
+
+// invokeErrorHandler runs the error handler, recovering from a panic so a faulty handler cannot crash the process.
+func (r *RedisAdapter) invokeErrorHandler(s *subscriber, topic string, payload []byte, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			logger.Error("Subscribe: error handler panic on topic %s: %v", topic, value)
+		}
+	}()
+	s.options.onError(topic, payload, err)
+}
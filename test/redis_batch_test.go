@@ -0,0 +1,112 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisBatch(t *testing.T) {
+	skipCI(t)
+
+	// Reject the heroes without a name
+	validate := func(next facilities.PublishFunc) facilities.PublishFunc {
+		return func(msg messaging.IMessage) error {
+			if msg.Payload().(*Hero).Name == "" {
+				return errors.New("missing name")
+			}
+			return next(msg)
+		}
+	}
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri, facilities.WithPublishMiddleware(validate))
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	_ = adapter.Del("batch_queue")
+	valid := newHeroMessage("batch_queue", NewHero1("1", 1, "Batch Hero").(*Hero))
+	invalid := newHeroMessage("batch_queue", NewHero1("2", 2, "").(*Hero))
+
+	// An atomic batch with an invalid message is not sent
+	result, err := adapter.PushBatch(facilities.BatchAtomic, valid, invalid)
+	require.Error(t, err)
+	require.Equal(t, 0, result.Sent())
+	require.ErrorIs(t, result[0].Err, facilities.ErrBatchAborted)
+	_, err = adapter.Pop(NewHeroMessage, 0, "batch_queue")
+	require.Error(t, err)
+
+	// A best-effort batch sends the valid messages
+	result, err = adapter.PushBatch(facilities.BatchBestEffort, valid, invalid, valid)
+	require.ErrorContains(t, err, "missing name")
+	require.Equal(t, 2, result.Sent())
+	require.NoError(t, result[0].Err)
+	require.Error(t, result[1].Err)
+	require.False(t, result[1].Delivered)
+	require.Equal(t, int64(2), result[2].Receivers)
+
+	for i := 0; i < 2; i++ {
+		message, er := adapter.Pop(NewHeroMessage, time.Second, "batch_queue")
+		require.NoError(t, er)
+		require.Equal(t, "Batch Hero", message.Payload().(*Hero).Name)
+	}
+
+	// The middlewares wrap the actual send of every message: calling next twice sends a message twice, like a single
+	// message, except in an atomic batch
+	var attempts atomic.Int32
+	twice := func(next facilities.PublishFunc) facilities.PublishFunc {
+		return func(msg messaging.IMessage) error {
+			attempts.Add(1)
+			if err := next(msg); err != nil {
+				return err
+			}
+			return next(msg)
+		}
+	}
+	retrier, err := facilities.NewRedisMessageBus(uri, facilities.WithPublishMiddleware(twice))
+	require.NoError(t, err)
+	result, err = retrier.(*facilities.RedisAdapter).PushBatch(facilities.BatchBestEffort, valid, valid)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Sent())
+	require.Equal(t, int64(4), adapter.LLen("batch_queue"))
+	result, err = retrier.(*facilities.RedisAdapter).PushBatch(facilities.BatchAtomic, valid)
+	require.ErrorIs(t, err, facilities.ErrBatchRetry)
+	require.True(t, result[0].Delivered, "the message is sent before the retry")
+	require.ErrorIs(t, result[0].Err, facilities.ErrBatchRetry)
+	require.NoError(t, retrier.Push(valid))
+	require.Equal(t, int32(4), attempts.Load())
+	require.Equal(t, int64(7), adapter.LLen("batch_queue"))
+	_ = adapter.Del("batch_queue")
+
+	// The receivers of published messages are reported
+	received := make(chan messaging.IMessage, 10)
+	subscriptionId, err := adapter.Subscribe("batch-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		received <- msg
+		return true
+	}, "batch_topic")
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	result, err = adapter.PublishBatch(facilities.BatchAtomic,
+		newHeroMessage("batch_topic", NewHero1("3", 3, "First Hero").(*Hero)),
+		newHeroMessage("batch_topic", NewHero1("4", 4, "Second Hero").(*Hero)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Sent())
+	require.Equal(t, int64(1), result[0].Receivers)
+	for _, name := range []string{"First Hero", "Second Hero"} {
+		select {
+		case msg := <-received:
+			require.Equal(t, name, msg.Payload().(*Hero).Name)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timeout waiting for message")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCacheGetOrLoad(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	cache, err := facilities.NewRedisDataCache(uri)
+	require.NoError(t, err)
+	require.NoError(t, cache.Ping(5, 5))
+
+	sut := cache.(*facilities.RedisAdapter)
+	hero := list_of_heroes[2]
+	key := fmt.Sprintf("loader:%s", hero.ID())
+	_ = sut.Del(key)
+
+	// The loader is slow, so all the concurrent callers hit a cold key
+	var calls int32
+	loader := func() (Entity, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return hero, nil
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, er := sut.GetOrLoad(NewHero, key, time.Minute, loader)
+			if assert.NoError(t, er) {
+				assert.Equal(t, hero.NAME(), result.NAME())
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls), "loader should be called once")
+
+	// The value is now cached
+	result, err := sut.Get(NewHero, key)
+	require.NoError(t, err)
+	require.Equal(t, hero.NAME(), result.NAME())
+
+	// While another process holds the load lock, the waiters time out instead of calling the loader
+	coldKey := fmt.Sprintf("loader:cold:%s", hero.ID())
+	_ = sut.Del(coldKey)
+	locker, err := sut.ObtainLocker("yaaf:loading:"+coldKey, 5*time.Second)
+	require.NoError(t, err)
+	defer func() { _ = locker.Release(context.Background()) }()
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = sut.GetOrLoad(NewHero, coldKey, time.Minute, loader, facilities.WithLoadLock(5*time.Second, 300*time.Millisecond))
+	require.ErrorIs(t, err, facilities.ErrLoadTimeout)
+	require.Equal(t, int32(0), atomic.LoadInt32(&calls), "loader should not be called without the lock")
+
+	// The load lock is an internal key, skipped by ScanKeys unless explicitly matched
+	for key, er := range sut.ScanKeys("*"+coldKey, "", 100) {
+		require.NoError(t, er)
+		require.NotEqual(t, "yaaf:loading:"+coldKey, key)
+	}
+	found := false
+	for key, er := range sut.ScanKeys("yaaf:loading:"+coldKey, "", 100) {
+		require.NoError(t, er)
+		found = found || key == "yaaf:loading:"+coldKey
+	}
+	require.True(t, found)
+
+	// With early refresh, the load duration is stored with the value, and no helper key is created
+	refreshKey := fmt.Sprintf("loader:refresh:%s", hero.ID())
+	_ = sut.Del(refreshKey)
+	_, err = sut.GetOrLoad(NewHero, refreshKey, time.Minute, loader, facilities.WithEarlyRefresh(1.0))
+	require.NoError(t, err)
+	result, err = sut.Get(NewHero, refreshKey)
+	require.NoError(t, err)
+	require.Equal(t, hero.NAME(), result.NAME())
+	for key, er := range sut.ScanKeys(refreshKey+"*", "", 100) {
+		require.NoError(t, er)
+		require.Equal(t, refreshKey, key)
+	}
+}
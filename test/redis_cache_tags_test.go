@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCacheInvalidateTags(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	cache, err := facilities.NewRedisDataCache(uri)
+	require.NoError(t, err)
+	require.NoError(t, cache.Ping(5, 5))
+
+	sut := cache.(*facilities.RedisAdapter)
+	hero := list_of_heroes[4]
+	tag := fmt.Sprintf("hero:%s", hero.ID())
+
+	// Cache the same hero under several keys and in a hash
+	require.NoError(t, sut.SetTagged(fmt.Sprintf("hero_by_id:%s", hero.ID()), hero, []string{tag}, time.Minute))
+	require.NoError(t, sut.SetTagged(fmt.Sprintf("hero_by_name:%s", hero.NAME()), hero, []string{tag}))
+	require.NoError(t, sut.HSetTagged("hero_list", hero.ID(), hero, []string{tag}))
+	require.NoError(t, sut.HSet("hero_list", "other", list_of_heroes[5]))
+
+	deleted, err := sut.InvalidateTags(tag)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), deleted)
+
+	exists, err := sut.Exists(fmt.Sprintf("hero_by_id:%s", hero.ID()))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = sut.HExists("hero_list", hero.ID())
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// Untagged fields are not affected
+	exists, err = sut.HExists("hero_list", "other")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// The tag indexes are reserved keys, a user key named like a tag index does not affect them
+	options, err := redis.ParseURL(uri)
+	require.NoError(t, err)
+	admin := redis.NewClient(options)
+	defer func() { _ = admin.Close() }()
+
+	require.NoError(t, sut.Set("tags:"+tag, hero))
+	require.NoError(t, sut.SetTagged(fmt.Sprintf("hero_by_name:%s", hero.NAME()), hero, []string{tag}))
+	require.NoError(t, sut.SetTagged(fmt.Sprintf("hero_by_id:%s", hero.ID()), hero, []string{tag}, 100*time.Millisecond))
+	members, err := admin.SMembers(context.Background(), "yaaf:tags:"+tag).Result()
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	// The members whose key expired are pruned when the tag is used again (the index has no expiration, as one of
+	// its entries has none)
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, sut.SetTagged(fmt.Sprintf("hero_by_name:%s", hero.NAME()), hero, []string{tag}))
+	members, err = admin.SMembers(context.Background(), "yaaf:tags:"+tag).Result()
+	require.NoError(t, err)
+	require.Equal(t, []string{fmt.Sprintf("hero_by_name:%s", hero.NAME())}, members)
+	_, _ = sut.InvalidateTags(tag)
+	require.NoError(t, sut.Del("tags:"+tag))
+}
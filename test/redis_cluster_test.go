@@ -0,0 +1,89 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// clusterURI is the connection string of the local Redis Cluster (see test_cluster/docker-compose.yml)
+const clusterURI = "redis://localhost:7000?addr=localhost:7001&addr=localhost:7002"
+
+func TestRedisClusterMultiKey(t *testing.T) {
+	skipCI(t)
+
+	cache, err := facilities.NewRedisDataCache(clusterURI)
+	require.NoError(t, err)
+	if err = cache.Ping(1, 1); err != nil {
+		t.Skip("Redis Cluster is not running (see test/test_cluster)")
+	}
+	sut := cache.(*facilities.RedisAdapter)
+
+	// The keys are spread over several hash slots (and nodes)
+	keys := make([]string, 0, len(list_of_heroes))
+	for _, hero := range list_of_heroes {
+		key := fmt.Sprintf("cluster_hero:%s", hero.ID())
+		require.NoError(t, sut.Set(key, hero, time.Minute))
+		keys = append(keys, key)
+	}
+
+	entities, err := sut.GetKeys(NewHero, keys...)
+	require.NoError(t, err)
+	require.Len(t, entities, len(keys))
+
+	tuples, err := sut.GetRawKeys(append(keys, "cluster_hero:missing")...)
+	require.NoError(t, err)
+	require.Len(t, tuples, len(keys))
+
+	scanned := 0
+	for _, er := range sut.ScanKeys("cluster_hero:*", "", 100) {
+		require.NoError(t, er)
+		scanned++
+	}
+	require.Equal(t, len(keys), scanned)
+
+	require.NoError(t, sut.Del(keys[:2]...))
+	deleted, err := sut.DelPattern("cluster_hero:*")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(keys)-2), deleted)
+
+	// Tagged keys and tag indexes on different hash slots
+	hero := list_of_heroes[0]
+	require.NoError(t, sut.SetTagged("cluster_by_id:"+hero.ID(), hero, []string{"cluster_tag_a", "cluster_tag_b"}, time.Minute))
+	require.NoError(t, sut.SetTagged("cluster_by_name:"+hero.NAME(), hero, []string{"cluster_tag_a"}, time.Minute))
+	require.NoError(t, sut.HSetTagged("cluster_heroes", hero.ID(), hero, []string{"cluster_tag_b"}))
+	invalidated, err := sut.InvalidateTags("cluster_tag_a", "cluster_tag_b")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), invalidated)
+	exists, err := sut.Exists("cluster_by_id:" + hero.ID())
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// Atomic multi-key operations require the keys on the same hash slot
+	require.NoError(t, sut.Set("{cluster_rename}:source", hero, time.Minute))
+	require.NoError(t, sut.Rename("{cluster_rename}:source", "{cluster_rename}:target"))
+	require.Error(t, sut.Rename("{cluster_rename}:target", "cluster_rename_other_slot"))
+	require.NoError(t, sut.Del("{cluster_rename}:target"))
+}
+
+func TestRedisClusterQueues(t *testing.T) {
+	skipCI(t)
+
+	bus, err := facilities.NewRedisMessageBus(clusterURI)
+	require.NoError(t, err)
+	if err = bus.Ping(1, 1); err != nil {
+		t.Skip("Redis Cluster is not running (see test/test_cluster)")
+	}
+
+	// Queues sharing a hash tag can be popped together
+	require.NoError(t, bus.Push(newHeroMessage("{cluster_queue}:low", NewHero1("1", 1, "Low Hero").(*Hero))))
+	message, err := bus.Pop(NewHeroMessage, time.Second, "{cluster_queue}:high", "{cluster_queue}:low")
+	require.NoError(t, err)
+	require.Equal(t, "Low Hero", message.Payload().(*Hero).Name)
+
+	_, err = bus.Pop(NewHeroMessage, time.Second, "cluster_queue_a", "cluster_queue_b")
+	require.Error(t, err, "queues on different hash slots are rejected")
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+)
+
+// codecHero is a hero without a binary marshaler, so every codec encodes it as a map of its fields
+type codecHero struct {
+	BaseEntity
+	Key  int    `json:"key"`
+	Name string `json:"name"`
+}
+
+func (a *codecHero) TABLE() string { return "hero" }
+func (a *codecHero) NAME() string  { return a.Name }
+
+func newCodecHero() Entity {
+	return &codecHero{}
+}
+
+func TestRedisCacheCodecs(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	cache, err := facilities.NewRedisDataCache(uri,
+		facilities.WithCodec(facilities.MsgPackCodec{}),
+		facilities.WithKeyCodec("cbor:", facilities.CBORCodec{}),
+		facilities.WithKeyCodec("gob:", facilities.GobCodec{}),
+		facilities.WithKeyCodec("json:", facilities.JSONCodec{}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, cache.Ping(5, 5))
+
+	// The stored bytes are encoded by the codec of the key prefix, or the default codec (msgpack)
+	isMsgPackMap := func(raw []byte) bool { return raw[0]&0xF0 == 0x80 || raw[0] == 0xDE }
+	isCBORMap := func(raw []byte) bool { return raw[0]&0xE0 == 0xA0 }
+	isGob := func(raw []byte) bool {
+		return !json.Valid(raw) && facilities.GobCodec{}.Unmarshal(raw, &codecHero{}) == nil
+	}
+	formats := map[string]func(raw []byte) bool{
+		"msgpack": isMsgPackMap,
+		"cbor":    isCBORMap,
+		"gob":     isGob,
+		"json":    json.Valid,
+	}
+
+	hero := &codecHero{BaseEntity: BaseEntity{Id: "7", CreatedOn: Now(), UpdatedOn: Now()}, Key: 7, Name: "Black Canary"}
+	for prefix, format := range formats {
+		key := fmt.Sprintf("%s:%s", prefix, hero.ID())
+		require.NoError(t, cache.Set(key, hero))
+
+		raw, er := cache.GetRaw(key)
+		require.NoError(t, er)
+		require.NotEmpty(t, raw)
+		require.True(t, format(raw), "%s key stored as % x", prefix, raw[:min(len(raw), 8)])
+
+		result, er := cache.Get(newCodecHero, key)
+		require.NoError(t, er)
+		require.Equal(t, hero, result)
+	}
+}
+
+func TestRedisMessageBusCodec(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri, facilities.WithTopicCodec("codec_queue", facilities.CBORCodec{}))
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+
+	message := newHeroMessage("codec_queue", list_of_heroes[7].(*Hero))
+	require.NoError(t, bus.Push(message))
+
+	result, err := bus.Pop(NewHeroMessage, 0, "codec_queue")
+	require.NoError(t, err)
+	require.Equal(t, message, result)
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCacheCompression(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	plain, err := facilities.NewRedisDataCache(uri)
+	require.NoError(t, err)
+	require.NoError(t, plain.Ping(5, 5))
+
+	hero := NewHero1("1000", 1000, strings.Repeat("Captain Compression ", 100))
+	for _, algorithm := range []facilities.Compression{facilities.Gzip, facilities.Zstd, facilities.Snappy, facilities.LZ4} {
+		cache, er := facilities.NewRedisDataCache(uri, facilities.WithCompression(algorithm, 256))
+		require.NoError(t, er)
+
+		key := fmt.Sprintf("compressed_hero:%s", algorithm)
+		require.NoError(t, cache.Set(key, hero))
+
+		raw, er := cache.GetRaw(key)
+		require.NoError(t, er)
+		require.Less(t, len(raw), len(hero.(*Hero).Name), "the value should be compressed")
+
+		// Readers detect compressed values regardless of their own settings
+		result, er := plain.Get(NewHero, key)
+		require.NoError(t, er)
+		require.Equal(t, hero, result)
+	}
+
+	// Plain values remain readable after compression is enabled
+	require.NoError(t, plain.Set("plain_hero", hero))
+	cache, err := facilities.NewRedisDataCache(uri, facilities.WithCompression(facilities.Zstd, 256))
+	require.NoError(t, err)
+	result, err := cache.Get(NewHero, "plain_hero")
+	require.NoError(t, err)
+	require.Equal(t, hero, result)
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisConsumerClose(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+
+	options, err := redis.ParseURL(uri)
+	require.NoError(t, err)
+	admin := redis.NewClient(options)
+	defer func() { _ = admin.Close() }()
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		c, er := bus.CreateConsumer("closing-consumer", NewHeroMessage, "closing_topic")
+		require.NoError(t, er)
+		consumer := c.(*facilities.RedisConsumer)
+
+		// Close wakes the blocked readers and closes the messages channel
+		reads := make(chan error, 1)
+		go func() {
+			_, e := consumer.Read(0)
+			reads <- e
+		}()
+		messages := consumer.Messages()
+		require.NoError(t, consumer.Close())
+		require.NoError(t, consumer.Close())
+
+		select {
+		case e := <-reads:
+			require.ErrorIs(t, e, facilities.ErrConsumerClosed)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "blocked read not woken by close")
+		}
+		_, open := <-messages
+		require.False(t, open)
+		_, er = consumer.Read(time.Second)
+		require.ErrorIs(t, er, facilities.ErrConsumerClosed)
+	}
+
+	// The connections and goroutines of the closed consumers are released
+	require.Eventually(t, func() bool {
+		counts, e := admin.PubSubNumSub(context.Background(), "closing_topic").Result()
+		return e == nil && counts["closing_topic"] == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= goroutines+5
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisConsumerReadContext(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+
+	c, err := bus.CreateConsumer("context-consumer", NewHeroMessage, "context_topic")
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	consumer := c.(*facilities.RedisConsumer)
+
+	// Timeouts are reported using the exported sentinel
+	_, err = consumer.Read(200 * time.Millisecond)
+	require.ErrorIs(t, err, facilities.ErrReadTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = consumer.ReadContext(ctx)
+	require.ErrorIs(t, err, facilities.ErrReadTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A canceled read is not a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = consumer.ReadContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, errors.Is(err, facilities.ErrReadTimeout))
+
+	require.NoError(t, bus.Publish(newHeroMessage("context_topic", NewHero1("1", 1, "Context Hero").(*Hero))))
+	message, err := consumer.ReadContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Context Hero", message.Payload().(*Hero).Name)
+
+	// Messages are delivered to the channel
+	messages := consumer.Messages()
+	require.NoError(t, bus.Publish(newHeroMessage("context_topic", NewHero1("2", 2, "Channel Hero").(*Hero))))
+	select {
+	case message = <-messages:
+		require.Equal(t, "Channel Hero", message.Payload().(*Hero).Name)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "message not received")
+	}
+}
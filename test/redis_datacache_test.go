@@ -4,6 +4,7 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-yaaf/yaaf-common-redis/redis"
 	"github.com/go-yaaf/yaaf-common/database"
@@ -120,3 +121,113 @@ func (s *RedisCacheTestSuite) TestDataCacheScan() {
 		}
 	}
 }
+
+// TestDataCacheScanKeys operation
+func (s *RedisCacheTestSuite) TestDataCacheScanKeys() {
+	adapter := s.cache.(*facilities.RedisAdapter)
+
+	count := 0
+	for key, err := range adapter.ScanKeys("hero:*", "string", 10) {
+		require.NoError(s.T(), err)
+		require.NotEmpty(s.T(), key)
+		count++
+	}
+	require.GreaterOrEqual(s.T(), count, len(list_of_heroes))
+}
+
+// TestDataCacheDelPattern operation
+func (s *RedisCacheTestSuite) TestDataCacheDelPattern() {
+	adapter := s.cache.(*facilities.RedisAdapter)
+
+	for _, item := range list_of_heroes {
+		require.NoError(s.T(), adapter.Set(fmt.Sprintf("temp_hero:%s", item.ID()), item))
+	}
+
+	deleted, err := adapter.DelPattern("temp_hero:*")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(len(list_of_heroes)), deleted)
+
+	for range adapter.ScanKeys("temp_hero:*", "", 100) {
+		s.T().Errorf("key not deleted")
+	}
+}
+
+// TestDataCacheHashScan operation
+func (s *RedisCacheTestSuite) TestDataCacheHashScan() {
+	adapter := s.cache.(*facilities.RedisAdapter)
+
+	for _, item := range list_of_heroes {
+		require.NoError(s.T(), adapter.HSet("heroes_hash", item.ID(), item))
+	}
+	require.NoError(s.T(), adapter.HSetRaw("heroes_hash", "corrupted", []byte("not a hero")))
+
+	length, err := adapter.HLen("heroes_hash")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(len(list_of_heroes)+1), length)
+
+	// Iterate the hash in chunks, the corrupted field is reported and the iteration continues
+	decoded, failed := 0, 0
+	for item, er := range adapter.HScan(NewHero, "heroes_hash", "", 10) {
+		var decodeError *facilities.DecodeError
+		if errors.As(er, &decodeError) {
+			require.Equal(s.T(), "corrupted", item.Key)
+			failed++
+		} else {
+			require.NoError(s.T(), er)
+			decoded++
+		}
+	}
+	require.Equal(s.T(), len(list_of_heroes), decoded)
+	require.Equal(s.T(), 1, failed)
+
+	// Partial read of selected fields
+	heroes, err := adapter.HMGet(NewHero, "heroes_hash", "1", "2", "missing")
+	require.NoError(s.T(), err)
+	require.Len(s.T(), heroes, 2)
+
+	// HGetAll reports the corrupted field instead of dropping it silently
+	all, err := adapter.HGetAll(NewHero, "heroes_hash")
+	require.Error(s.T(), err)
+	require.Len(s.T(), all, len(list_of_heroes))
+}
+
+// TestDataCacheListOperations operation
+func (s *RedisCacheTestSuite) TestDataCacheListOperations() {
+	adapter := s.cache.(*facilities.RedisAdapter)
+	_ = adapter.Del("heroes_feed", "heroes_archive")
+
+	// Capped feed keeps only the newest 10 heroes
+	for _, item := range list_of_heroes {
+		require.NoError(s.T(), adapter.LPushCapped("heroes_feed", 10, item))
+	}
+	require.Equal(s.T(), int64(10), adapter.LLen("heroes_feed"))
+
+	// The newest hero is at the head of the list
+	head, err := adapter.LIndex(NewHero, "heroes_feed", 0)
+	require.NoError(s.T(), err)
+	last := list_of_heroes[len(list_of_heroes)-1]
+	require.Equal(s.T(), last.NAME(), head.NAME())
+
+	pos, err := adapter.LPos("heroes_feed", last)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(0), pos)
+
+	// Replace the head, and insert a hero after it
+	require.NoError(s.T(), adapter.LSet("heroes_feed", 0, list_of_heroes[0]))
+	length, err := adapter.LInsert("heroes_feed", false, list_of_heroes[0], list_of_heroes[1])
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(11), length)
+
+	removed, err := adapter.LRem("heroes_feed", 0, list_of_heroes[1])
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(1), removed)
+
+	// Move the head of the feed to the tail of the archive
+	moved, err := adapter.LMove(NewHero, "heroes_feed", "heroes_archive", facilities.ListLeft, facilities.ListRight)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), list_of_heroes[0].NAME(), moved.NAME())
+	require.Equal(s.T(), int64(1), adapter.LLen("heroes_archive"))
+
+	require.NoError(s.T(), adapter.LTrim("heroes_feed", 0, 4))
+	require.Equal(s.T(), int64(5), adapter.LLen("heroes_feed"))
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDeduplication(t *testing.T) {
+	skipCI(t)
+
+	// An id function is required, the session id is shared by distinct messages
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	_, err := facilities.NewRedisMessageBus(uri, facilities.WithDeduplication(time.Minute, nil))
+	require.ErrorIs(t, err, facilities.ErrDeduplicationId)
+	_, err = facilities.NewRedisMessageBus(uri, facilities.WithDeduplication(0, func(msg messaging.IMessage) string { return "" }))
+	require.ErrorIs(t, err, facilities.ErrDeduplicationWindow)
+
+	// Deduplicate the messages by hero id
+	heroId := func(msg messaging.IMessage) string { return msg.Payload().(*Hero).Id }
+
+	bus, err := facilities.NewRedisMessageBus(uri, facilities.WithDeduplication(time.Minute, heroId))
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	clone, err := bus.CloneMessageBus()
+	require.NoError(t, err)
+
+	// Two instances of the same subscriber process every hero once
+	var processed atomic.Int32
+	for _, b := range []messaging.IMessageBus{bus, clone} {
+		subscriptionId, er := b.Subscribe("dedup-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+			processed.Add(1)
+			return true
+		}, "dedup_topic")
+		require.NoError(t, er)
+		defer b.Unsubscribe(subscriptionId)
+	}
+
+	suffix := NanoID()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, bus.Publish(newHeroMessage("dedup_topic", NewHero1("sub-"+suffix, 1, "Retried Hero").(*Hero))))
+	}
+	require.NoError(t, bus.Publish(newHeroMessage("dedup_topic", NewHero1("sub-other-"+suffix, 2, "Other Hero").(*Hero))))
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, int32(2), processed.Load())
+
+	// Duplicates are skipped by Pop
+	require.NoError(t, bus.Push(
+		newHeroMessage("dedup_queue", NewHero1("pop-"+suffix, 1, "Retried Hero").(*Hero)),
+		newHeroMessage("dedup_queue", NewHero1("pop-"+suffix, 1, "Retried Hero").(*Hero)),
+		newHeroMessage("dedup_queue", NewHero1("pop-other-"+suffix, 2, "Other Hero").(*Hero)),
+	))
+	message, err := bus.Pop(NewHeroMessage, time.Second, "dedup_queue")
+	require.NoError(t, err)
+	require.Equal(t, "Retried Hero", message.Payload().(*Hero).Name)
+	message, err = clone.Pop(NewHeroMessage, time.Second, "dedup_queue")
+	require.NoError(t, err)
+	require.Equal(t, "Other Hero", message.Payload().(*Hero).Name)
+	_, err = bus.Pop(NewHeroMessage, 0, "dedup_queue")
+	require.Error(t, err)
+
+	// After a duplicate, Pop keeps waiting for the sub-second part of its timeout
+	require.NoError(t, bus.Push(newHeroMessage("dedup_queue", NewHero1("pop-"+suffix, 1, "Retried Hero").(*Hero))))
+	go func() {
+		time.Sleep(1200 * time.Millisecond)
+		_ = bus.Push(newHeroMessage("dedup_queue", NewHero1("pop-late-"+suffix, 3, "Late Hero").(*Hero)))
+	}()
+	message, err = bus.Pop(NewHeroMessage, 1500*time.Millisecond, "dedup_queue")
+	require.NoError(t, err)
+	require.Equal(t, "Late Hero", message.Payload().(*Hero).Name)
+
+	// A batch of duplicates only is not found, like by Pop
+	require.NoError(t, bus.Push(
+		newHeroMessage("dedup_queue", NewHero1("pop-"+suffix, 1, "Retried Hero").(*Hero)),
+		newHeroMessage("dedup_queue", NewHero1("pop-other-"+suffix, 2, "Other Hero").(*Hero)),
+	))
+	messages, err := clone.(*facilities.RedisAdapter).PopBatch(NewHeroMessage, 10, 0, "dedup_queue")
+	require.ErrorIs(t, err, redis.Nil)
+	require.Empty(t, messages)
+
+	// After a batch of duplicates, PopBatch keeps waiting for the rest of its timeout, like Pop
+	require.NoError(t, bus.Push(newHeroMessage("dedup_queue", NewHero1("pop-"+suffix, 1, "Retried Hero").(*Hero))))
+	go func() {
+		time.Sleep(1200 * time.Millisecond)
+		_ = bus.Push(newHeroMessage("dedup_queue", NewHero1("pop-batch-late-"+suffix, 4, "Late Hero").(*Hero)))
+	}()
+	messages, err = clone.(*facilities.RedisAdapter).PopBatch(NewHeroMessage, 10, 1500*time.Millisecond, "dedup_queue")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, "Late Hero", messages[0].Payload().(*Hero).Name)
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCacheEncryption(t *testing.T) {
+	skipCI(t)
+
+	masterKey := make([]byte, 32)
+	_, _ = rand.Read(masterKey)
+	keyRing, err := facilities.NewKeyRing("key-1", masterKey)
+	require.NoError(t, err)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	cache, err := facilities.NewRedisDataCache(uri, facilities.WithKeyEncryption(keyRing, "pii:"))
+	require.NoError(t, err)
+	require.NoError(t, cache.Ping(5, 5))
+
+	hero := list_of_heroes[8]
+	require.NoError(t, cache.Set("pii:hero", hero))
+
+	// The value is not readable in redis
+	raw, err := cache.GetRaw("pii:hero")
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(raw, []byte(hero.NAME())))
+
+	// Rotate the master key, values written with the old key remain readable
+	newKey := make([]byte, 32)
+	_, _ = rand.Read(newKey)
+	require.NoError(t, keyRing.Rotate("key-2", newKey))
+	require.NoError(t, cache.Set("pii:hero2", hero))
+
+	for _, key := range []string{"pii:hero", "pii:hero2"} {
+		result, er := cache.Get(NewHero, key)
+		require.NoError(t, er)
+		require.Equal(t, hero, result)
+	}
+
+	// Keys without the prefix are not encrypted
+	require.NoError(t, cache.Set("public:hero", hero))
+	raw, err = cache.GetRaw("public:hero")
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(raw, []byte(hero.NAME())))
+
+	// Elements of encrypted lists cannot be matched by value, only by their stored bytes
+	adapter := cache.(*facilities.RedisAdapter)
+	_ = adapter.Del("pii:heroes")
+	require.NoError(t, adapter.RPush("pii:heroes", hero, list_of_heroes[9]))
+	_, err = adapter.LPos("pii:heroes", hero)
+	require.ErrorIs(t, err, facilities.ErrEncryptedComparison)
+	_, err = adapter.LInsert("pii:heroes", true, hero, list_of_heroes[7])
+	require.ErrorIs(t, err, facilities.ErrEncryptedComparison)
+	_, err = adapter.LRem("pii:heroes", 0, hero)
+	require.ErrorIs(t, err, facilities.ErrEncryptedComparison)
+
+	stored, err := adapter.LRangeRaw("pii:heroes", 0, 0)
+	require.NoError(t, err)
+	removed, err := adapter.LRemRaw("pii:heroes", 0, stored[0])
+	require.NoError(t, err)
+	require.Equal(t, int64(1), removed)
+	require.NoError(t, adapter.Del("pii:heroes"))
+}
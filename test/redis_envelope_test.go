@@ -0,0 +1,81 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+// TracedHeroMessage is a hero message exposing its envelope headers
+type TracedHeroMessage struct {
+	messaging.BaseMessage
+	facilities.Envelope
+	Hero *Hero `json:"hero"`
+}
+
+func (m *TracedHeroMessage) Payload() any { return m.Hero }
+
+func NewTracedHeroMessage() messaging.IMessage {
+	return &TracedHeroMessage{}
+}
+
+func TestRedisMessageEnvelope(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri, facilities.WithEnvelopes(), facilities.WithProducerId("envelope-test"))
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	queue := "envelope_queue_" + NanoID()
+	defer func() { _, _ = adapter.PurgeQueue(queue) }()
+
+	// The headers of the sent message are received along with the automatic ones, which cannot be overridden
+	message := &TracedHeroMessage{Hero: NewHero1("1", 1, "Traced Hero").(*Hero)}
+	message.MsgTopic = queue
+	message.MsgVersion = "2"
+	message.SetHeaders(facilities.Headers{"traceparent": "00-trace-span-01", facilities.HeaderMessageId: "forged"})
+	require.NoError(t, adapter.Push(message))
+
+	received, err := adapter.Pop(NewTracedHeroMessage, 5*time.Second, queue)
+	require.NoError(t, err)
+	headers := received.(*TracedHeroMessage).Headers()
+	require.Equal(t, "Traced Hero", received.Payload().(*Hero).Name)
+	require.Equal(t, "00-trace-span-01", headers["traceparent"])
+	require.Equal(t, "envelope-test", headers[facilities.HeaderProducer])
+	require.Equal(t, "2", headers[facilities.HeaderSchemaVersion])
+	require.NotEmpty(t, headers[facilities.HeaderMessageId])
+	require.NotEqual(t, "forged", headers[facilities.HeaderMessageId])
+	require.NotEmpty(t, headers[facilities.HeaderTimestamp])
+
+	// A received message sent again gets a new message id
+	messageId := headers[facilities.HeaderMessageId]
+	require.NoError(t, adapter.Push(received))
+	received, err = adapter.Pop(NewTracedHeroMessage, 5*time.Second, queue)
+	require.NoError(t, err)
+	require.NotEqual(t, messageId, received.(*TracedHeroMessage).Headers()[facilities.HeaderMessageId])
+	require.Equal(t, "00-trace-span-01", received.(*TracedHeroMessage).Headers()["traceparent"])
+
+	// Bare payloads (sent without WithEnvelopes, or by older versions) are received without headers
+	legacy, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	defer func() { _ = legacy.Close() }()
+	require.NoError(t, legacy.Push(newHeroMessage(queue, NewHero1("2", 2, "Legacy Hero").(*Hero))))
+
+	received, err = adapter.Pop(NewTracedHeroMessage, 5*time.Second, queue)
+	require.NoError(t, err)
+	require.Equal(t, "Legacy Hero", received.Payload().(*Hero).Name)
+	require.Nil(t, received.(*TracedHeroMessage).Headers())
+
+	// Messages without headers support are received from envelopes as usual
+	require.NoError(t, adapter.Push(newHeroMessage(queue, NewHero1("3", 3, "Plain Hero").(*Hero))))
+	received, err = legacy.Pop(NewHeroMessage, 5*time.Second, queue)
+	require.NoError(t, err)
+	require.Equal(t, "Plain Hero", received.Payload().(*Hero).Name)
+}
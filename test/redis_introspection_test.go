@@ -0,0 +1,62 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisIntrospection(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri, facilities.WithNamespace("introspection"))
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	received := make(chan messaging.IMessage, 10)
+	subscriptionId, err := adapter.Subscribe("introspection-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		received <- msg
+		return true
+	}, "heroes_topic", "villains_topic")
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	// Publishing to a topic without subscribers is detected
+	receivers, err := adapter.PublishCount(newHeroMessage("nobody_topic", NewHero1("1", 1, "Lonely Hero").(*Hero)))
+	require.NoError(t, err)
+	require.Zero(t, receivers)
+
+	receivers, err = adapter.PublishCount(newHeroMessage("heroes_topic", NewHero1("2", 2, "Popular Hero").(*Hero)))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), receivers)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for message")
+	}
+
+	topics, err := adapter.ActiveTopics("*_topic")
+	require.NoError(t, err)
+	require.Equal(t, []string{"heroes_topic", "villains_topic"}, topics)
+
+	counts, err := adapter.TopicSubscribers("heroes_topic", "nobody_topic")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"heroes_topic": 1, "nobody_topic": 0}, counts)
+
+	_, err = adapter.PatternSubscriptions()
+	require.NoError(t, err)
+
+	subscriptions := adapter.Subscriptions()
+	require.Len(t, subscriptions, 1)
+	require.Equal(t, subscriptionId, subscriptions[0].Id)
+	require.Equal(t, []string{"heroes_topic", "villains_topic"}, subscriptions[0].Topics)
+	require.False(t, subscriptions[0].IsPattern)
+	require.Equal(t, int64(1), subscriptions[0].Health.Received)
+	require.Zero(t, subscriptions[0].Health.Failed)
+}
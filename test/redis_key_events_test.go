@@ -0,0 +1,43 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisKeyEvents(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	cache, err := facilities.NewRedisDataCache(uri)
+	require.NoError(t, err)
+	require.NoError(t, cache.Ping(5, 5))
+	adapter := cache.(*facilities.RedisAdapter)
+
+	events := make(chan facilities.KeyEvent, 10)
+	subscriptionId, err := adapter.SubscribeKeyEvents("session:*", func(event facilities.KeyEvent) {
+		events <- event
+	}, facilities.WithKeyEventTypes(facilities.KeyExpired, facilities.KeyDel), facilities.WithKeyEventsAutoEnable())
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	require.NoError(t, cache.Set("session:1", NewHero1("1", 1, "Session Hero"), 100*time.Millisecond))
+	require.NoError(t, cache.Set("session:2", NewHero1("2", 2, "Session Hero")))
+	require.NoError(t, cache.Del("session:2"))
+
+	received := make(map[string]facilities.KeyEventType)
+	for len(received) < 2 {
+		select {
+		case event := <-events:
+			received[event.Key] = event.Event
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timeout waiting for key events")
+		}
+	}
+	require.Equal(t, facilities.KeyExpired, received["session:1"])
+	require.Equal(t, facilities.KeyDel, received["session:2"])
+}
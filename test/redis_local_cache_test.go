@@ -0,0 +1,48 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLocalCache(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	first, err := facilities.NewRedisDataCache(uri, facilities.WithLocalCache(100, time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, first.Ping(5, 5))
+	second, err := first.CloneDataCache()
+	require.NoError(t, err)
+	defer func() { _ = first.Close(); _ = second.Close() }()
+
+	local := first.(*facilities.RedisAdapter)
+	require.NoError(t, first.Set("local_hero", NewHero1("1", 1, "First Hero")))
+	for i := 0; i < 10; i++ {
+		result, er := first.Get(NewHero, "local_hero")
+		require.NoError(t, er)
+		require.Equal(t, "First Hero", result.(*Hero).Name)
+	}
+
+	stats := local.LocalCacheStats()
+	require.InDelta(t, 0.9, stats.HitRatio(), 0.001)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(9), stats.Hits)
+
+	// A write through another adapter invalidates the local cache
+	require.NoError(t, second.Set("local_hero", NewHero1("1", 1, "Second Hero")))
+	require.Eventually(t, func() bool {
+		result, er := first.Get(NewHero, "local_hero")
+		return er == nil && result.(*Hero).Name == "Second Hero"
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, second.Del("local_hero"))
+	require.Eventually(t, func() bool {
+		_, er := first.Get(NewHero, "local_hero")
+		return er != nil
+	}, time.Second, 10*time.Millisecond)
+}
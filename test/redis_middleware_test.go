@@ -0,0 +1,101 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisMiddleware(t *testing.T) {
+	skipCI(t)
+
+	var mu sync.Mutex
+	trace := make([]string, 0)
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, step)
+	}
+
+	// Drop the messages of heroes without a name
+	validate := func(next messaging.SubscriptionCallback) messaging.SubscriptionCallback {
+		return func(msg messaging.IMessage) bool {
+			if msg.Payload().(*Hero).Name == "" {
+				record("dropped")
+				return false
+			}
+			record("validated")
+			return next(msg)
+		}
+	}
+	logging := func(next messaging.SubscriptionCallback) messaging.SubscriptionCallback {
+		return func(msg messaging.IMessage) bool {
+			record("logged")
+			return next(msg)
+		}
+	}
+	publishing := func(next facilities.PublishFunc) facilities.PublishFunc {
+		return func(msg messaging.IMessage) error {
+			record("published")
+			return next(msg)
+		}
+	}
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri,
+		facilities.WithSubscriptionMiddleware(validate),
+		facilities.WithPublishMiddleware(publishing),
+	)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	received := make(chan messaging.IMessage, 10)
+	subscriptionId, err := adapter.SubscribeWithOptions("middleware-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		received <- msg
+		return true
+	}, []string{"middleware_topic"}, facilities.WithMiddleware(logging))
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	consumer, err := bus.CreateConsumer("middleware-consumer", NewHeroMessage, "middleware_topic")
+	require.NoError(t, err)
+	defer func() { _ = consumer.Close() }()
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, bus.Publish(newHeroMessage("middleware_topic", NewHero1("1", 1, "").(*Hero))))
+	require.NoError(t, bus.Publish(newHeroMessage("middleware_topic", NewHero1("2", 2, "Valid Hero").(*Hero))))
+
+	// The invalid message is dropped by the subscription and skipped by the consumer
+	select {
+	case msg := <-received:
+		require.Equal(t, "Valid Hero", msg.Payload().(*Hero).Name)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for message")
+	}
+	msg, err := consumer.Read(5 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "Valid Hero", msg.Payload().(*Hero).Name)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 2, countOf(trace, "published"))
+	require.Equal(t, 2, countOf(trace, "dropped"))
+	require.Equal(t, 2, countOf(trace, "validated"))
+	require.Equal(t, 1, countOf(trace, "logged"))
+}
+
+// countOf counts the occurrences of a value in a slice
+func countOf(values []string, value string) (count int) {
+	for _, v := range values {
+		if v == value {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisNamespace(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	plain, err := facilities.NewRedisDataCache(uri)
+	require.NoError(t, err)
+	require.NoError(t, plain.Ping(5, 5))
+
+	orders, err := facilities.NewRedisDataCache(uri, facilities.WithNamespace("orders"))
+	require.NoError(t, err)
+	billing, err := facilities.NewRedisDataCache(uri, facilities.WithNamespace("billing"))
+	require.NoError(t, err)
+
+	// The same key in different namespaces holds different values
+	require.NoError(t, orders.Set("ns_hero:1", NewHero1("1", 1, "Orders Hero")))
+	require.NoError(t, billing.Set("ns_hero:1", NewHero1("1", 1, "Billing Hero")))
+
+	result, err := orders.Get(NewHero, "ns_hero:1")
+	require.NoError(t, err)
+	require.Equal(t, "Orders Hero", result.(*Hero).Name)
+
+	// The key is stored with the namespace prefix
+	result, err = plain.Get(NewHero, "billing:ns_hero:1")
+	require.NoError(t, err)
+	require.Equal(t, "Billing Hero", result.(*Hero).Name)
+
+	// Keys are returned without the namespace prefix
+	keys, _, err := orders.Scan(0, "ns_hero:*", 1000)
+	require.NoError(t, err)
+	require.Contains(t, keys, "ns_hero:1")
+
+	tuples, err := orders.GetRawKeys("ns_hero:1", "ns_hero:2")
+	require.NoError(t, err)
+	require.Len(t, tuples, 1)
+	require.Equal(t, "ns_hero:1", tuples[0].Key)
+
+	// Clones keep the namespace
+	clone, err := orders.CloneDataCache()
+	require.NoError(t, err)
+	exists, err := clone.Exists("ns_hero:1")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	require.NoError(t, orders.Del("ns_hero:1"))
+	exists, err = billing.Exists("ns_hero:1")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, billing.Del("ns_hero:1"))
+}
+
+func TestRedisNamespaceMessageBus(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	orders, err := facilities.NewRedisMessageBus(uri, facilities.WithNamespace("orders"))
+	require.NoError(t, err)
+	clone, err := orders.CloneMessageBus()
+	require.NoError(t, err)
+	other, err := facilities.NewRedisMessageBus(uri, facilities.WithNamespace("billing"))
+	require.NoError(t, err)
+
+	// Queues of different namespaces are isolated
+	require.NoError(t, other.Push(newHeroMessage("ns_queue", NewHero1("1", 1, "Billing Hero").(*Hero))))
+	require.NoError(t, orders.Push(newHeroMessage("ns_queue", NewHero1("2", 2, "Orders Hero").(*Hero))))
+
+	message, err := clone.Pop(NewHeroMessage, time.Second, "ns_queue")
+	require.NoError(t, err)
+	require.Equal(t, "Orders Hero", message.Payload().(*Hero).Name)
+
+	message, err = other.Pop(NewHeroMessage, time.Second, "ns_queue")
+	require.NoError(t, err)
+	require.Equal(t, "Billing Hero", message.Payload().(*Hero).Name)
+}
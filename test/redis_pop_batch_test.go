@@ -0,0 +1,57 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisPopBatch(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+	_ = adapter.Del("pop_batch_queue", "pop_batch_empty_queue")
+
+	// Blocks until the first message is pushed, then drains up to the batch size
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		for i := 1; i <= 5; i++ {
+			_ = bus.Push(newHeroMessage("pop_batch_queue", NewHero1(fmt.Sprintf("%d", i), i, "Batch Hero").(*Hero)))
+		}
+	}()
+	messages, err := adapter.PopBatch(NewHeroMessage, 3, 5*time.Second, "pop_batch_empty_queue", "pop_batch_queue")
+	require.NoError(t, err)
+	require.NotEmpty(t, messages)
+	require.Equal(t, 1, messages[0].Payload().(*Hero).Key)
+
+	time.Sleep(500 * time.Millisecond)
+	remaining := 5 - len(messages)
+
+	// A message which fails to decode is reported, the others are returned
+	require.NoError(t, adapter.LPushRaw("pop_batch_queue", []byte("not a message")))
+	messages, err = adapter.PopBatch(NewHeroMessage, 10, 0, "pop_batch_queue")
+	var decodeErr *facilities.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Len(t, decodeErr.Failed, 1)
+	require.Len(t, messages, remaining)
+
+	// Without a timeout, the first non-empty queue is drained
+	require.NoError(t, bus.Push(
+		newHeroMessage("pop_batch_queue", NewHero1("6", 6, "Batch Hero").(*Hero)),
+		newHeroMessage("pop_batch_queue", NewHero1("7", 7, "Batch Hero").(*Hero)),
+	))
+	messages, err = adapter.PopBatch(NewHeroMessage, 10, 0, "pop_batch_empty_queue", "pop_batch_queue")
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	_, err = adapter.PopBatch(NewHeroMessage, 10, 0, "pop_batch_empty_queue", "pop_batch_queue")
+	require.ErrorIs(t, err, redis.Nil)
+}
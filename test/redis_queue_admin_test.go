@@ -0,0 +1,77 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisQueueAdmin(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+	_ = adapter.Del("admin_queue", "admin_dead_queue", "yaaf:admin_queue")
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, bus.Push(newHeroMessage("admin_queue", NewHero1(fmt.Sprintf("%d", i), i, "Queued Hero").(*Hero))))
+	}
+
+	length, err := adapter.QueueLength("admin_queue")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), length)
+
+	// Peek returns the next messages in the order they would be popped
+	messages, err := adapter.PeekQueue(NewHeroMessage, "admin_queue", 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.Equal(t, 1, messages[0].Payload().(*Hero).Key)
+	require.Equal(t, 2, messages[1].Payload().(*Hero).Key)
+
+	removed, err := adapter.RemoveFromQueue(NewHeroMessage, "admin_queue", func(msg messaging.IMessage) bool {
+		return msg.Payload().(*Hero).Key%2 == 0
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), removed)
+
+	_, err = adapter.MoveQueue("admin_queue", "admin_queue", 0)
+	require.ErrorIs(t, err, facilities.ErrSameQueue)
+
+	moved, err := adapter.MoveQueue("admin_queue", "admin_dead_queue", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), moved)
+	message, err := bus.Pop(NewHeroMessage, 0, "admin_dead_queue")
+	require.NoError(t, err)
+	require.Equal(t, 1, message.Payload().(*Hero).Key)
+
+	// Moving all the messages pipelines the moves
+	moved, err = adapter.MoveQueue("admin_queue", "admin_dead_queue", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), moved)
+	moved, err = adapter.MoveQueue("admin_dead_queue", "admin_queue", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), moved)
+
+	// The internal lists of the adapter are not queues
+	require.NoError(t, bus.Push(newHeroMessage("yaaf:admin_queue", NewHero1("6", 6, "Internal Hero").(*Hero))))
+	queues, err := adapter.ListQueues("*admin_*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"admin_queue"}, queues)
+	queues, err = adapter.ListQueues("yaaf:admin_*")
+	require.NoError(t, err)
+	require.Empty(t, queues)
+	_ = adapter.Del("yaaf:admin_queue")
+
+	purged, err := adapter.PurgeQueue("admin_queue")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), purged)
+	length, err = adapter.QueueLength("admin_queue")
+	require.NoError(t, err)
+	require.Zero(t, length)
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisRequestReply(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	// The responder renames the hero, or fails for heroes without a name
+	handler := func(request messaging.IMessage) (messaging.IMessage, error) {
+		hero := request.Payload().(*Hero)
+		if hero.Name == "" {
+			return nil, errors.New("missing name")
+		}
+		if hero.Name == "Slow Hero" {
+			time.Sleep(2 * time.Second)
+		}
+		if hero.Name == "Busy Hero" {
+			time.Sleep(1200 * time.Millisecond)
+		}
+		return newHeroMessage("rpc_reply", NewHero1(hero.Id, hero.Key, "Renamed "+hero.Name).(*Hero)), nil
+	}
+	responderId, err := adapter.Respond("rpc-responder", NewHeroMessage, handler, []string{"rpc_topic"})
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(responderId)
+	queueResponderId, err := adapter.RespondQueue("rpc-queue-responder", NewHeroMessage, handler, []string{"rpc_queue"})
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(queueResponderId)
+
+	reply, err := adapter.Request(newHeroMessage("rpc_topic", NewHero1("1", 1, "Hero").(*Hero)), NewHeroMessage, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "Renamed Hero", reply.Payload().(*Hero).Name)
+
+	reply, err = adapter.Request(newHeroMessage("rpc_queue", NewHero1("2", 2, "Queued Hero").(*Hero)), NewHeroMessage, 5*time.Second, facilities.WithRequestQueue())
+	require.NoError(t, err)
+	require.Equal(t, "Renamed Queued Hero", reply.Payload().(*Hero).Name)
+
+	// The responder error is returned to the requester
+	_, err = adapter.Request(newHeroMessage("rpc_topic", NewHero1("3", 3, "").(*Hero)), NewHeroMessage, 5*time.Second)
+	var remoteErr *facilities.RemoteError
+	require.ErrorAs(t, err, &remoteErr)
+	require.Equal(t, "missing name", remoteErr.Message)
+
+	// Unanswered requests time out, and their late reply is discarded
+	_, err = adapter.Request(newHeroMessage("rpc_topic", NewHero1("4", 4, "Slow Hero").(*Hero)), NewHeroMessage, time.Second)
+	require.ErrorIs(t, err, facilities.ErrRequestTimeout)
+	_, err = adapter.Request(newHeroMessage("rpc_nobody", NewHero1("5", 5, "Hero").(*Hero)), NewHeroMessage, time.Second)
+	require.ErrorIs(t, err, facilities.ErrRequestTimeout)
+
+	// Timeouts are not rounded to whole seconds
+	reply, err = adapter.Request(newHeroMessage("rpc_topic", NewHero1("6", 6, "Busy Hero").(*Hero)), NewHeroMessage, 1500*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, "Renamed Busy Hero", reply.Payload().(*Hero).Name)
+	start := time.Now()
+	_, err = adapter.Request(newHeroMessage("rpc_nobody", NewHero1("7", 7, "Hero").(*Hero)), NewHeroMessage, 1500*time.Millisecond)
+	require.ErrorIs(t, err, facilities.ErrRequestTimeout)
+	require.InDelta(t, 1500*time.Millisecond, time.Since(start), float64(250*time.Millisecond))
+
+	time.Sleep(2 * time.Second)
+	for key, er := range adapter.ScanKeys("yaaf:rpc:reply:*", "", 100) {
+		require.NoError(t, er)
+		require.Fail(t, "late reply was not discarded", key)
+	}
+}
@@ -0,0 +1,241 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common-redis/redis"
+	"github.com/go-yaaf/yaaf-common/messaging"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSubscriptionResubscribe(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	received := make(chan messaging.IMessage, 10)
+	subscriptionId, err := adapter.SubscribeWithOptions("resubscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		received <- msg
+		return true
+	}, []string{"resubscribe_topic"}, facilities.WithHealthCheck(time.Second), facilities.WithResubscribe(0, time.Second))
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	publish := func(name string) {
+		require.NoError(t, bus.Publish(newHeroMessage("resubscribe_topic", NewHero1("1", 1, name).(*Hero))))
+		select {
+		case msg := <-received:
+			require.Equal(t, name, msg.Payload().(*Hero).Name)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timeout waiting for message")
+		}
+	}
+	publish("Before Drop")
+
+	// Kill the subscription connection, the subscription is re-established automatically
+	options, err := redis.ParseURL(uri)
+	require.NoError(t, err)
+	admin := redis.NewClient(options)
+	defer func() { _ = admin.Close() }()
+	require.NoError(t, admin.Do(context.Background(), "CLIENT", "KILL", "TYPE", "pubsub").Err())
+
+	require.Eventually(t, func() bool {
+		health, ok := adapter.SubscriptionHealth(subscriptionId)
+		return ok && health.State == facilities.SubscriptionActive && health.Reconnects == 1
+	}, 10*time.Second, 50*time.Millisecond)
+	publish("After Drop")
+
+	health, ok := adapter.SubscriptionHealth(subscriptionId)
+	require.True(t, ok)
+	require.False(t, health.LastMessage.IsZero())
+	require.Equal(t, facilities.SubscriptionActive, health.State)
+	require.Equal(t, 1, health.Reconnects)
+}
+
+func TestRedisSubscriptionShutdown(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	var processed atomic.Int32
+	subscriptionId, err := adapter.Subscribe("slow-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		time.Sleep(500 * time.Millisecond)
+		processed.Add(1)
+		return true
+	}, "shutdown_topic")
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, bus.Publish(newHeroMessage("shutdown_topic", NewHero1("1", 1, "Slow Hero").(*Hero))))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// All the in-flight messages are processed before the adapter is closed
+	inFlight, err := adapter.Shutdown(5 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 0, inFlight)
+	require.Equal(t, int32(5), processed.Load())
+
+	_, exists := adapter.SubscriptionHealth(subscriptionId)
+	require.False(t, exists)
+}
+
+func TestRedisSubscriptionOrdering(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	// Messages of the same hero are processed in order, messages of different heroes concurrently
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	var wg sync.WaitGroup
+	wg.Add(100)
+	subscriptionId, err := adapter.SubscribeWithOptions("ordered-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		defer wg.Done()
+		hero := msg.Payload().(*Hero)
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		received[hero.ID()] = append(received[hero.ID()], hero.Key)
+		mu.Unlock()
+		return true
+	}, []string{"ordering_topic"},
+		facilities.WithConcurrency(4),
+		facilities.WithOrderingKey(func(msg messaging.IMessage) string { return msg.Payload().(*Hero).ID() }),
+	)
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, bus.Publish(newHeroMessage("ordering_topic", NewHero1(fmt.Sprintf("%d", i%5), i, "Ordered Hero").(*Hero))))
+	}
+	wg.Wait()
+
+	for id, keys := range received {
+		require.IsIncreasing(t, keys, "hero %s", id)
+	}
+}
+
+func TestRedisSubscriptionOverflow(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	// Without a buffer, there is no older message to drop
+	_, err = adapter.SubscribeWithOptions("overflow-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		return true
+	}, []string{"overflow_topic"}, facilities.WithOverflow(facilities.OverflowDropOldest, 0))
+	require.ErrorIs(t, err, facilities.ErrInvalidOverflow)
+
+	// A single busy worker with 2 pending messages: the newest messages are dropped
+	release := make(chan struct{})
+	var processed atomic.Int32
+	subscriptionId, err := adapter.SubscribeWithOptions("overflow-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		<-release
+		processed.Add(1)
+		return true
+	}, []string{"overflow_topic"},
+		facilities.WithOrdering(facilities.OrderSequential),
+		facilities.WithOverflow(facilities.OverflowDropNewest, 2),
+	)
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, bus.Publish(newHeroMessage("overflow_topic", NewHero1("1", i, "Busy Hero").(*Hero))))
+	}
+	require.Eventually(t, func() bool {
+		health, _ := adapter.SubscriptionHealth(subscriptionId)
+		return health.Dropped == 7
+	}, 5*time.Second, 50*time.Millisecond)
+
+	close(release)
+	require.Eventually(t, func() bool { return processed.Load() == 3 }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestRedisSubscriptionErrors(t *testing.T) {
+	skipCI(t)
+
+	uri := fmt.Sprintf("redis://localhost:%s", dbPort)
+	bus, err := facilities.NewRedisMessageBus(uri)
+	require.NoError(t, err)
+	require.NoError(t, bus.Ping(5, 5))
+	adapter := bus.(*facilities.RedisAdapter)
+
+	// Failed messages are forwarded to the error topic
+	forwarded := make(chan []byte, 10)
+	options, err := redis.ParseURL(uri)
+	require.NoError(t, err)
+	admin := redis.NewClient(options)
+	defer func() { _ = admin.Close() }()
+	deadLetters := admin.Subscribe(context.Background(), "errors_dead_topic")
+	defer func() { _ = deadLetters.Close() }()
+	_, err = deadLetters.Receive(context.Background())
+	require.NoError(t, err)
+	go func() {
+		for msg := range deadLetters.Channel() {
+			forwarded <- []byte(msg.Payload)
+		}
+	}()
+
+	errs := make(chan error, 10)
+	subscriptionId, err := adapter.SubscribeWithOptions("panicking-subscriber", NewHeroMessage, func(msg messaging.IMessage) bool {
+		panic("callback failure")
+	}, []string{"errors_topic"},
+		facilities.WithErrorTopic("errors_dead_topic"),
+		facilities.WithErrorHandler(func(topic string, payload []byte, err error) {
+			errs <- err
+		}),
+	)
+	require.NoError(t, err)
+	defer adapter.Unsubscribe(subscriptionId)
+
+	// A panicking callback is recovered and reported
+	require.NoError(t, bus.Publish(newHeroMessage("errors_topic", NewHero1("1", 1, "Panic Hero").(*Hero))))
+	select {
+	case err = <-errs:
+		var panicErr *facilities.CallbackPanicError
+		require.ErrorAs(t, err, &panicErr)
+		require.Equal(t, "callback failure", panicErr.Value)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for the panic error")
+	}
+
+	// A message which cannot be decoded is reported
+	require.NoError(t, admin.Publish(context.Background(), "errors_topic", "not a message").Err())
+	select {
+	case err = <-errs:
+		require.ErrorContains(t, err, "unmarshal")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout waiting for the decode error")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-forwarded:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timeout waiting for the forwarded message")
+		}
+	}
+}